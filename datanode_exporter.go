@@ -6,12 +6,14 @@ import (
 	"os"
 	"net/http"
 	"io"
-	"github.com/parnurzeal/gorequest"
 	"github.com/robfig/cron"
-	"time"
 	"fmt"
 	"sync"
 	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/ximply/hadoop_exporter/internal/collector"
 )
 
 var (
@@ -82,13 +84,21 @@ type DataNodeInfo struct {
 	SendDataPacketTransferNanosAvgTime float64
 
 	GcTimeMillis float64
-	GcTimeMillisParNew float64
-	GcTimeMillisConcurrentMarkSweep float64
 	GcCount float64
-	GcCountParNew float64
-	GcCountConcurrentMarkSweep float64
+	// Per-collector GC times and counts, only reported by JVMs running the
+	// ParNew and CMS collectors.
+	GcCollectors map[string]float64
 	ThreadsBlocked float64
 	ThreadsWaiting float64
+
+	Version string
+	Revision string
+	ClusterId string
+	// Block pools served, one per namespace, comma-separated.
+	BlockPoolIds string
+	RuntimeInfo collector.Runtime
+
+	Quantiles []collector.Quantile
 }
 
 
@@ -96,103 +106,135 @@ func metrics(w http.ResponseWriter, r *http.Request) {
 	g_lock.RLock()
 	io.WriteString(w, g_ret)
 	g_lock.RUnlock()
+	io.WriteString(w, collector.BreakerMetrics())
 }
 
-func info() (DataNodeInfo, bool) {
+func info(c *collector.Collection) (DataNodeInfo, bool) {
 	ret := DataNodeInfo {
 	}
 
-	hostName, osErr := os.Hostname()
-	if osErr != nil {
-		return ret, false
+	// Without a hostname the DataNodeActivity bean cannot be recognized and
+	// is reported as missing, the other beans are still collected.
+	hostName, err := os.Hostname()
+	if err != nil {
+		collector.LogAt(collector.LevelError, "cannot determine hostname", "target", c.Target, "err", err)
 	}
 
 	// http://localhost:50075/jmx
-	req := gorequest.New()
-	_, body, errs := req.Retry(1, 5 * time.Second,
-		http.StatusBadRequest, http.StatusInternalServerError).Get(*dataNodeJmxUrl).End()
-	if errs != nil {
+	body, ok := collector.Fetch(c.Target)
+	if !ok {
 		return ret, false
 	}
 
 	var f interface{}
-	err := json.Unmarshal([]byte(body), &f)
+	err = json.Unmarshal([]byte(body), &f)
 	if err != nil {
+		collector.LogAt(collector.LevelError, "cannot decode payload", "target", c.Target, "err", err)
 		return ret, false
 	}
-	m := f.(map[string]interface{})
-	var nameList = m["beans"].([]interface{})
+	m, _ := f.(map[string]interface{})
+	nameList, ok := m["beans"].([]interface{})
+	if !ok {
+		collector.LogAt(collector.LevelError, "payload has no beans", "target", c.Target)
+		return ret, false
+	}
+	c.Up["jmx"] = true
 	for _, nameData := range nameList {
-		nameDataMap := nameData.(map[string]interface{})
+		nameDataMap, _ := nameData.(map[string]interface{})
+		ret.Quantiles = append(ret.Quantiles, collector.Quantiles(nameDataMap)...)
 
 		if nameDataMap["name"] == "java.lang:type=Memory" {
-			heapMemoryUsage := nameDataMap["HeapMemoryUsage"].(map[string]interface{})
-			ret.HeapMemoryUsageCommitted = heapMemoryUsage["committed"].(float64)
-			ret.HeapMemoryUsageInit = heapMemoryUsage["init"].(float64)
-			ret.HeapMemoryUsageMax = heapMemoryUsage["max"].(float64)
-			ret.HeapMemoryUsageUsed = heapMemoryUsage["used"].(float64)
+			get := c.Bean("Memory", nameDataMap["HeapMemoryUsage"])
+			ret.HeapMemoryUsageCommitted = get("committed")
+			ret.HeapMemoryUsageInit = get("init")
+			ret.HeapMemoryUsageMax = get("max")
+			ret.HeapMemoryUsageUsed = get("used")
+		}
+
+		if hostName != "" && nameDataMap["name"] == "Hadoop:service=DataNode,name=DataNodeActivity-" + hostName + "-50010" {
+			get := c.Bean("DataNodeActivity", nameDataMap)
+			ret.BytesWritten = get("BytesWritten")
+			ret.BytesRead = get("BytesRead")
+
+			ret.BlocksWritten = get("BlocksWritten")
+			ret.BlocksRead = get("BlocksRead")
+			ret.BlocksReplicated = get("BlocksReplicated")
+			ret.BlocksRemoved = get("BlocksRemoved")
+			ret.BlocksVerified = get("BlocksVerified")
+			ret.BlockVerificationFailures = get("BlockVerificationFailures")
+
+			ret.ReadsFromLocalClient = get("ReadsFromLocalClient")
+			ret.ReadsFromRemoteClient = get("ReadsFromRemoteClient")
+			ret.WritesFromLocalClient = get("WritesFromLocalClient")
+			ret.WritesFromRemoteClient = get("WritesFromRemoteClient")
+
+			ret.BlocksGetLocalPathInfo = get("BlocksGetLocalPathInfo")
+			ret.FsyncCount = get("FsyncCount")
+			ret.VolumeFailures = get("VolumeFailures")
+
+			ret.ReadBlockOpNumOps = get("ReadBlockOpNumOps")
+			ret.ReadBlockOpAvgTime = get("ReadBlockOpAvgTime")
+			ret.WriteBlockOpNumOps = get("WriteBlockOpNumOps")
+			ret.WriteBlockOpAvgTime = get("WriteBlockOpAvgTime")
+			ret.BlockChecksumOpNumOps = get("BlockChecksumOpNumOps")
+			ret.BlockChecksumOpAvgTime = get("BlockChecksumOpAvgTime")
+			ret.CopyBlockOpNumOps = get("CopyBlockOpNumOps")
+			ret.CopyBlockOpAvgTime = get("CopyBlockOpAvgTime")
+			ret.ReplaceBlockOpNumOps = get("ReplaceBlockOpNumOps")
+			ret.ReplaceBlockOpAvgTime = get("ReplaceBlockOpAvgTime")
+
+			ret.HeartbeatsNumOps = get("HeartbeatsNumOps")
+			ret.HeartbeatsAvgTime = get("HeartbeatsAvgTime")
+
+			ret.BlockReportsNumOps = get("BlockReportsNumOps")
+			ret.BlockReportsAvgTime = get("BlockReportsAvgTime")
+
+			ret.PacketAckRoundTripTimeNanosNumOps = get("PacketAckRoundTripTimeNanosNumOps")
+			ret.PacketAckRoundTripTimeNanosAvgTime = get("PacketAckRoundTripTimeNanosAvgTime")
+
+			ret.FlushNanosNumOps = get("FlushNanosNumOps")
+			ret.FlushNanosAvgTime = get("FlushNanosAvgTime")
+			ret.FsyncNanosNumOps = get("FsyncNanosNumOps")
+			ret.FsyncNanosAvgTime = get("FsyncNanosAvgTime")
+
+			ret.SendDataPacketBlockedOnNetworkNanosNumOps = get("SendDataPacketBlockedOnNetworkNanosNumOps")
+			ret.SendDataPacketBlockedOnNetworkNanosAvgTime = get("SendDataPacketBlockedOnNetworkNanosAvgTime")
+			ret.SendDataPacketTransferNanosNumOps = get("SendDataPacketTransferNanosNumOps")
+			ret.SendDataPacketTransferNanosAvgTime = get("SendDataPacketTransferNanosAvgTime")
 		}
 
-		if nameDataMap["name"] == "Hadoop:service=DataNode,name=DataNodeActivity-" + hostName + "-50010" {
-			ret.BytesWritten = nameDataMap["BytesWritten"].(float64)
-			ret.BytesRead = nameDataMap["BytesRead"].(float64)
-
-			ret.BlocksWritten = nameDataMap["BlocksWritten"].(float64)
-			ret.BlocksRead = nameDataMap["BlocksRead"].(float64)
-			ret.BlocksReplicated = nameDataMap["BlocksReplicated"].(float64)
-			ret.BlocksRemoved = nameDataMap["BlocksRemoved"].(float64)
-			ret.BlocksVerified = nameDataMap["BlocksVerified"].(float64)
-			ret.BlockVerificationFailures = nameDataMap["BlockVerificationFailures"].(float64)
-
-			ret.ReadsFromLocalClient = nameDataMap["ReadsFromLocalClient"].(float64)
-			ret.ReadsFromRemoteClient = nameDataMap["ReadsFromRemoteClient"].(float64)
-			ret.WritesFromLocalClient = nameDataMap["WritesFromLocalClient"].(float64)
-			ret.WritesFromRemoteClient = nameDataMap["WritesFromRemoteClient"].(float64)
-
-			ret.BlocksGetLocalPathInfo = nameDataMap["BlocksGetLocalPathInfo"].(float64)
-			ret.FsyncCount = nameDataMap["FsyncCount"].(float64)
-			ret.VolumeFailures = nameDataMap["VolumeFailures"].(float64)
-
-			ret.ReadBlockOpNumOps = nameDataMap["ReadBlockOpNumOps"].(float64)
-			ret.ReadBlockOpAvgTime = nameDataMap["ReadBlockOpAvgTime"].(float64)
-			ret.WriteBlockOpNumOps = nameDataMap["WriteBlockOpNumOps"].(float64)
-			ret.WriteBlockOpAvgTime = nameDataMap["WriteBlockOpAvgTime"].(float64)
-			ret.BlockChecksumOpNumOps = nameDataMap["BlockChecksumOpNumOps"].(float64)
-			ret.BlockChecksumOpAvgTime = nameDataMap["BlockChecksumOpAvgTime"].(float64)
-			ret.CopyBlockOpNumOps = nameDataMap["CopyBlockOpNumOps"].(float64)
-			ret.CopyBlockOpAvgTime = nameDataMap["CopyBlockOpAvgTime"].(float64)
-			ret.ReplaceBlockOpNumOps = nameDataMap["ReplaceBlockOpNumOps"].(float64)
-			ret.ReplaceBlockOpAvgTime = nameDataMap["ReplaceBlockOpAvgTime"].(float64)
-
-			ret.HeartbeatsNumOps = nameDataMap["HeartbeatsNumOps"].(float64)
-			ret.HeartbeatsAvgTime = nameDataMap["HeartbeatsAvgTime"].(float64)
-
-			ret.BlockReportsNumOps = nameDataMap["BlockReportsNumOps"].(float64)
-			ret.BlockReportsAvgTime = nameDataMap["BlockReportsAvgTime"].(float64)
-
-			ret.PacketAckRoundTripTimeNanosNumOps = nameDataMap["PacketAckRoundTripTimeNanosNumOps"].(float64)
-			ret.PacketAckRoundTripTimeNanosAvgTime = nameDataMap["PacketAckRoundTripTimeNanosAvgTime"].(float64)
-
-			ret.FlushNanosNumOps = nameDataMap["FlushNanosNumOps"].(float64)
-			ret.FlushNanosAvgTime = nameDataMap["FlushNanosAvgTime"].(float64)
-			ret.FsyncNanosNumOps = nameDataMap["FsyncNanosNumOps"].(float64)
-			ret.FsyncNanosAvgTime = nameDataMap["FsyncNanosAvgTime"].(float64)
-
-			ret.SendDataPacketBlockedOnNetworkNanosNumOps = nameDataMap["SendDataPacketBlockedOnNetworkNanosNumOps"].(float64)
-			ret.SendDataPacketBlockedOnNetworkNanosAvgTime = nameDataMap["SendDataPacketBlockedOnNetworkNanosAvgTime"].(float64)
-			ret.SendDataPacketTransferNanosNumOps = nameDataMap["SendDataPacketTransferNanosNumOps"].(float64)
-			ret.SendDataPacketTransferNanosAvgTime = nameDataMap["SendDataPacketTransferNanosAvgTime"].(float64)
+		if nameDataMap["name"] == "java.lang:type=Runtime" {
+			ret.RuntimeInfo = collector.RuntimeInfo(c, nameDataMap)
+		}
+
+		if nameDataMap["name"] == "Hadoop:service=DataNode,name=DataNodeInfo" {
+			c.Bean("DataNodeInfo", nameDataMap)
+			version, _ := nameDataMap["Version"].(string)
+			ret.Version, ret.Revision = collector.SplitVersion(version)
+			ret.ClusterId, _ = nameDataMap["ClusterId"].(string)
+			var actors []map[string]interface{}
+			raw, _ := nameDataMap["BPServiceActorInfo"].(string)
+			if err := json.Unmarshal([]byte(raw), &actors); err == nil {
+				var ids []string
+				for _, actor := range actors {
+					if id, ok := actor["BlockPoolID"].(string); ok && id != "" {
+						ids = append(ids, id)
+					}
+				}
+				sort.Strings(ids)
+				ret.BlockPoolIds = strings.Join(ids, ",")
+			}
 		}
 
 		if nameDataMap["name"] == "Hadoop:service=DataNode,name=JvmMetrics" {
-			ret.GcTimeMillis = nameDataMap["GcTimeMillis"].(float64)
-			ret.GcTimeMillisParNew = nameDataMap["GcTimeMillisParNew"].(float64)
-			ret.GcTimeMillisConcurrentMarkSweep = nameDataMap["GcTimeMillisConcurrentMarkSweep"].(float64)
-			ret.GcCount = nameDataMap["GcCount"].(float64)
-			ret.GcCountParNew = nameDataMap["GcCountParNew"].(float64)
-			ret.GcCountConcurrentMarkSweep = nameDataMap["GcCountConcurrentMarkSweep"].(float64)
-			ret.ThreadsBlocked = nameDataMap["ThreadsBlocked"].(float64)
-			ret.ThreadsWaiting = nameDataMap["ThreadsWaiting"].(float64)
+			get := c.Bean("JvmMetrics", nameDataMap)
+			ret.GcTimeMillis = get("GcTimeMillis")
+			ret.GcCount = get("GcCount")
+			ret.GcCollectors = collector.Optional(nameDataMap,
+				"GcTimeMillisParNew", "GcTimeMillisConcurrentMarkSweep",
+				"GcCountParNew", "GcCountConcurrentMarkSweep")
+			ret.ThreadsBlocked = get("ThreadsBlocked")
+			ret.ThreadsWaiting = get("ThreadsWaiting")
 		}
 	}
 
@@ -205,132 +247,150 @@ func doWork() {
 	}
 	g_doing = true
 
-	s, ok := info()
-	if !ok {
-		g_doing = false
-		return
-	}
+	c := collector.NewCollection(*dataNodeJmxUrl, "jmx", "Memory", "DataNodeActivity", "JvmMetrics", "DataNodeInfo", "Runtime")
+	s, _ := info(c)
 
 	ret := ""
 	nameSpace := "hadoop_"
 
-	ret += fmt.Sprintf("%s_heap_memory{type=\"committed\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.HeapMemoryUsageCommitted)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"init\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.HeapMemoryUsageInit)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"max\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.HeapMemoryUsageMax)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"used\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.HeapMemoryUsageUsed)
-
-
-	ret += fmt.Sprintf("%s_bytes_written{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BytesWritten)
-	ret += fmt.Sprintf("%s_bytes_read{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BytesRead)
-
-	ret += fmt.Sprintf("%s_blocks_written{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BlocksWritten)
-	ret += fmt.Sprintf("%s_blocks_read{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BlocksRead)
-	ret += fmt.Sprintf("%s_blocks_replicated{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BlocksReplicated)
-	ret += fmt.Sprintf("%s_blocks_removed{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BlocksRemoved)
-	ret += fmt.Sprintf("%s_blocks_verified{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BlocksVerified)
-	ret += fmt.Sprintf("%s_block_verification_failures{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BlockVerificationFailures)
-
-	ret += fmt.Sprintf("%s_reads_from_local_client{role=\"%s\"} %g\n",
-		nameSpace, *role, s.ReadsFromLocalClient)
-	ret += fmt.Sprintf("%s_reads_from_remote_client{role=\"%s\"} %g\n",
-		nameSpace, *role, s.ReadsFromRemoteClient)
-	ret += fmt.Sprintf("%s_writes_from_local_client{role=\"%s\"} %g\n",
-		nameSpace, *role, s.WritesFromLocalClient)
-	ret += fmt.Sprintf("%s_writes_from_remote_client{role=\"%s\"} %g\n",
-		nameSpace, *role, s.WritesFromRemoteClient)
-
-	ret += fmt.Sprintf("%s_blocks_get_local_path_info{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BlocksGetLocalPathInfo)
-	ret += fmt.Sprintf("%s_fsync_count{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FsyncCount)
-	ret += fmt.Sprintf("%s_volume_failures{role=\"%s\"} %g\n",
-		nameSpace, *role, s.VolumeFailures)
-
-	ret += fmt.Sprintf("%s_read_block_op_uum_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.ReadBlockOpNumOps)
-	ret += fmt.Sprintf("%s_read_block_op_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.ReadBlockOpAvgTime)
-	ret += fmt.Sprintf("%s_write_block_op_uum_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.WriteBlockOpNumOps)
-	ret += fmt.Sprintf("%s_write_block_op_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.WriteBlockOpAvgTime)
-	ret += fmt.Sprintf("%s_block_checksum_op_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BlockChecksumOpNumOps)
-	ret += fmt.Sprintf("%s_block_checksum_op_vvg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BlockChecksumOpAvgTime)
-	ret += fmt.Sprintf("%s_copy_block_op_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.CopyBlockOpNumOps)
-	ret += fmt.Sprintf("%s_copy_block_op_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.CopyBlockOpAvgTime)
-	ret += fmt.Sprintf("%s_replace_block_op_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.ReplaceBlockOpNumOps)
-	ret += fmt.Sprintf("%s_replace_block_op_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.ReplaceBlockOpAvgTime)
-
-	ret += fmt.Sprintf("%s_heartbeats_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.HeartbeatsNumOps)
-	ret += fmt.Sprintf("%s_heartbeats_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.HeartbeatsAvgTime)
-
-	ret += fmt.Sprintf("%s_block_reports_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BlockReportsNumOps)
-	ret += fmt.Sprintf("%s_block_reports_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.BlockReportsAvgTime)
-
-	ret += fmt.Sprintf("%s_packet_ack_roundtrip_time_nanos_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.PacketAckRoundTripTimeNanosNumOps)
-	ret += fmt.Sprintf("%s_packet_ack_roundtrip_time_nanos_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.PacketAckRoundTripTimeNanosAvgTime)
-
-	ret += fmt.Sprintf("%s_flush_nanos_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FlushNanosNumOps)
-	ret += fmt.Sprintf("%s_flush_nanos_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FlushNanosAvgTime)
-	ret += fmt.Sprintf("%s_fsync_nanos_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FsyncNanosNumOps)
-	ret += fmt.Sprintf("%s_fsync_nanos_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FsyncNanosAvgTime)
-
-	ret += fmt.Sprintf("%s_senddata_packet_blocked_on_network_nanos_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.SendDataPacketBlockedOnNetworkNanosNumOps)
-	ret += fmt.Sprintf("%s_senddata_packet_blocked_on_network_nanos_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.SendDataPacketBlockedOnNetworkNanosAvgTime)
-	ret += fmt.Sprintf("%s_senddata_packet_transfer_nanos_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.SendDataPacketTransferNanosNumOps)
-	ret += fmt.Sprintf("%s_senddata_packet_transfer_nanos_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.SendDataPacketTransferNanosAvgTime)
-
-
-
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_time_total_millis{role=\"%s\"} %g\n",
-		nameSpace, *role, s.GcTimeMillis)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"par_new\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.GcTimeMillisParNew)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"concurrent_mark_sweep\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.GcTimeMillisConcurrentMarkSweep)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_count_total{role=\"%s\"} %g\n",
-		nameSpace, *role, s.GcCount)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_count{type=\"par_new\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.GcCountParNew)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_count{type=\"concurrent_mark_sweep\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.GcCountConcurrentMarkSweep)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_blocked{role=\"%s\"} %g\n",
-		nameSpace, *role, s.ThreadsBlocked)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_waiting{role=\"%s\"} %g\n",
-		nameSpace, *role, s.ThreadsWaiting)
+	if c.Up["Memory"] {
+		ret += fmt.Sprintf("%s_heap_memory{type=\"committed\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.HeapMemoryUsageCommitted)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"init\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.HeapMemoryUsageInit)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"max\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.HeapMemoryUsageMax)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"used\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.HeapMemoryUsageUsed)
+	}
+
+	if c.Up["DataNodeActivity"] {
+		ret += fmt.Sprintf("%s_bytes_written{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BytesWritten)
+		ret += fmt.Sprintf("%s_bytes_read{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BytesRead)
+
+		ret += fmt.Sprintf("%s_blocks_written{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BlocksWritten)
+		ret += fmt.Sprintf("%s_blocks_read{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BlocksRead)
+		ret += fmt.Sprintf("%s_blocks_replicated{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BlocksReplicated)
+		ret += fmt.Sprintf("%s_blocks_removed{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BlocksRemoved)
+		ret += fmt.Sprintf("%s_blocks_verified{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BlocksVerified)
+		ret += fmt.Sprintf("%s_block_verification_failures{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BlockVerificationFailures)
+
+		ret += fmt.Sprintf("%s_reads_from_local_client{role=\"%s\"} %g\n",
+			nameSpace, *role, s.ReadsFromLocalClient)
+		ret += fmt.Sprintf("%s_reads_from_remote_client{role=\"%s\"} %g\n",
+			nameSpace, *role, s.ReadsFromRemoteClient)
+		ret += fmt.Sprintf("%s_writes_from_local_client{role=\"%s\"} %g\n",
+			nameSpace, *role, s.WritesFromLocalClient)
+		ret += fmt.Sprintf("%s_writes_from_remote_client{role=\"%s\"} %g\n",
+			nameSpace, *role, s.WritesFromRemoteClient)
+
+		ret += fmt.Sprintf("%s_blocks_get_local_path_info{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BlocksGetLocalPathInfo)
+		ret += fmt.Sprintf("%s_fsync_count{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FsyncCount)
+		ret += fmt.Sprintf("%s_volume_failures{role=\"%s\"} %g\n",
+			nameSpace, *role, s.VolumeFailures)
+
+		ret += fmt.Sprintf("%s_read_block_op_uum_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.ReadBlockOpNumOps)
+		ret += fmt.Sprintf("%s_read_block_op_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.ReadBlockOpAvgTime)
+		ret += fmt.Sprintf("%s_write_block_op_uum_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.WriteBlockOpNumOps)
+		ret += fmt.Sprintf("%s_write_block_op_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.WriteBlockOpAvgTime)
+		ret += fmt.Sprintf("%s_block_checksum_op_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BlockChecksumOpNumOps)
+		ret += fmt.Sprintf("%s_block_checksum_op_vvg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BlockChecksumOpAvgTime)
+		ret += fmt.Sprintf("%s_copy_block_op_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.CopyBlockOpNumOps)
+		ret += fmt.Sprintf("%s_copy_block_op_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.CopyBlockOpAvgTime)
+		ret += fmt.Sprintf("%s_replace_block_op_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.ReplaceBlockOpNumOps)
+		ret += fmt.Sprintf("%s_replace_block_op_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.ReplaceBlockOpAvgTime)
+
+		ret += fmt.Sprintf("%s_heartbeats_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.HeartbeatsNumOps)
+		ret += fmt.Sprintf("%s_heartbeats_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.HeartbeatsAvgTime)
+
+		ret += fmt.Sprintf("%s_block_reports_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BlockReportsNumOps)
+		ret += fmt.Sprintf("%s_block_reports_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.BlockReportsAvgTime)
+
+		ret += fmt.Sprintf("%s_packet_ack_roundtrip_time_nanos_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.PacketAckRoundTripTimeNanosNumOps)
+		ret += fmt.Sprintf("%s_packet_ack_roundtrip_time_nanos_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.PacketAckRoundTripTimeNanosAvgTime)
+
+		ret += fmt.Sprintf("%s_flush_nanos_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FlushNanosNumOps)
+		ret += fmt.Sprintf("%s_flush_nanos_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FlushNanosAvgTime)
+		ret += fmt.Sprintf("%s_fsync_nanos_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FsyncNanosNumOps)
+		ret += fmt.Sprintf("%s_fsync_nanos_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FsyncNanosAvgTime)
+
+		ret += fmt.Sprintf("%s_senddata_packet_blocked_on_network_nanos_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.SendDataPacketBlockedOnNetworkNanosNumOps)
+		ret += fmt.Sprintf("%s_senddata_packet_blocked_on_network_nanos_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.SendDataPacketBlockedOnNetworkNanosAvgTime)
+		ret += fmt.Sprintf("%s_senddata_packet_transfer_nanos_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.SendDataPacketTransferNanosNumOps)
+		ret += fmt.Sprintf("%s_senddata_packet_transfer_nanos_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.SendDataPacketTransferNanosAvgTime)
+	}
+
+	if c.Up["JvmMetrics"] {
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_time_total_millis{role=\"%s\"} %g\n",
+			nameSpace, *role, s.GcTimeMillis)
+		if v, ok := s.GcCollectors["GcTimeMillisParNew"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"par_new\",role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		if v, ok := s.GcCollectors["GcTimeMillisConcurrentMarkSweep"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"concurrent_mark_sweep\",role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_count_total{role=\"%s\"} %g\n",
+			nameSpace, *role, s.GcCount)
+		if v, ok := s.GcCollectors["GcCountParNew"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_count{type=\"par_new\",role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		if v, ok := s.GcCollectors["GcCountConcurrentMarkSweep"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_count{type=\"concurrent_mark_sweep\",role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_blocked{role=\"%s\"} %g\n",
+			nameSpace, *role, s.ThreadsBlocked)
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_waiting{role=\"%s\"} %g\n",
+			nameSpace, *role, s.ThreadsWaiting)
+	}
+
+	if c.Up["DataNodeInfo"] {
+		ret += collector.BuildInfo("version", s.Version, "revision", s.Revision, "cluster_id", s.ClusterId,
+			"block_pool_id", s.BlockPoolIds, "jvm_version", s.RuntimeInfo.JvmVersion)
+	}
+	if c.Up["Runtime"] {
+		ret += collector.RuntimeMetrics(s.RuntimeInfo)
+	}
 
+	ret += collector.QuantileMetrics(s.Quantiles)
+	ret += collector.SourceMetrics(c)
 
 	g_lock.Lock()
 	g_ret = ret
@@ -341,6 +401,10 @@ func doWork() {
 
 func main() {
 	flag.Parse()
+	if err := collector.Init(Name, *role); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	g_doing = false
 	doWork()
@@ -350,12 +414,15 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc(*metricsPath, metrics)
+	mux.HandleFunc("/log/level", collector.LogLevel)
+	mux.HandleFunc("/debug/targets", collector.DebugTargets)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
              <head><title>Hadoop Data Node Exporter</title></head>
              <body>
              <h1>Hadoop Data Node Exporter</h1>
              <p><a href='` + *metricsPath + `'>Metrics</a></p>
+             <p><a href='/debug/targets'>Targets</a></p>
              </body>
              </html>`))
 	})
@@ -0,0 +1,172 @@
+// Package collector holds what the Hadoop exporters share: fetching with
+// retries and a circuit breaker, tracking which sources of a payload were
+// collected, structured logging, /debug/targets and the metrics common to
+// every Hadoop daemon.
+package collector
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	fetchTimeout     = flag.Duration("fetch.timeout", 10*time.Second, "Timeout of a single request to Hadoop.")
+	fetchRetries     = flag.Int("fetch.retries", 3, "Number of times a failed request is retried.")
+	fetchBackoff     = flag.Duration("fetch.backoff", time.Second, "Delay before the first retry, doubled on every further retry.")
+	fetchMaxBackoff  = flag.Duration("fetch.max-backoff", 30*time.Second, "Upper bound of the delay between retries.")
+	breakerThreshold = flag.Int("breaker.threshold", 3, "Consecutive failed fetches after which the circuit breaker of a target opens.")
+	breakerCooldown  = flag.Duration("breaker.cooldown", 5*time.Minute, "How long an open circuit breaker skips its target.")
+	logLevelName     = flag.String("log.level", "info", "Minimum level of logged messages: debug, info, warn or error.")
+	logFormat        = flag.String("log.format", "logfmt", "Log line format: logfmt or json.")
+	logRateLimit     = flag.Duration("log.rate-limit", 10*time.Minute, "Interval within which repeated log lines about the same target and bean are dropped.")
+)
+
+// Name and role of the exporter, as set by Init.
+var g_name string
+var g_role string
+
+var g_sourceErrors = map[string]float64{}
+var g_decodeErrors = map[string]float64{}
+
+// Init sets the name and role the exporter reports with and applies the
+// shared flags. It is called by main once flags are parsed.
+func Init(name, role string) error {
+	level, ok := parseLevel(*logLevelName)
+	if !ok {
+		return fmt.Errorf("unknown log level %q, want one of %s",
+			*logLevelName, strings.Join(levelNames, ", "))
+	}
+	known := false
+	for _, format := range logFormats {
+		known = known || *logFormat == format
+	}
+	if !known {
+		return fmt.Errorf("unknown log format %q, want one of %s",
+			*logFormat, strings.Join(logFormats, ", "))
+	}
+	g_logLevel = level
+	g_name = name
+	g_role = role
+	rand.Seed(time.Now().UnixNano())
+	return nil
+}
+
+// Collection records which sources of one fetched payload were collected:
+// the payload itself and each of the beans expected in it, along with the
+// attributes that could not be decoded.
+type Collection struct {
+	Target  string
+	Sources []string
+	Up      map[string]bool
+	Failed  map[string][]string
+}
+
+func NewCollection(target string, sources ...string) *Collection {
+	return &Collection{
+		Target:  target,
+		Sources: sources,
+		Up:      map[string]bool{},
+		Failed:  map[string][]string{},
+	}
+}
+
+// Bean marks source as collected when v is a JSON object and returns a
+// reader for its numeric attributes. A missing or non-numeric attribute
+// reads as 0 and is recorded as a decode failure of source. When the whole
+// bean is missing every attribute reads as 0 without being recorded: that
+// counts as a source error only.
+func (c *Collection) Bean(source string, v interface{}) func(string) float64 {
+	attrs, ok := v.(map[string]interface{})
+	c.Up[source] = ok
+	return func(attr string) float64 {
+		f, ok := attrs[attr].(float64)
+		if !ok && attrs != nil {
+			c.Fail(source, attr, attrs[attr])
+		}
+		return f
+	}
+}
+
+// Optional returns those of attrs that the bean v has as numbers. It is
+// meant for attributes missing from some Hadoop versions, so absent ones are
+// not decode failures.
+func Optional(v interface{}, attrs ...string) map[string]float64 {
+	bean, _ := v.(map[string]interface{})
+	ret := map[string]float64{}
+	for _, attr := range attrs {
+		if f, ok := bean[attr].(float64); ok {
+			ret[attr] = f
+		}
+	}
+	return ret
+}
+
+// Fail records that attr of source, found with value, could not be decoded.
+func (c *Collection) Fail(source, attr string, value interface{}) {
+	c.Failed[source] = append(c.Failed[source], attr)
+	LogAt(LevelWarn, "cannot decode attribute", "target", c.Target,
+		"bean", source, "attribute", attr, "value", value)
+}
+
+// SourceMetrics renders whether each source was collected in this cycle,
+// and counts failed sources and undecodable attributes across cycles. The
+// first source of a collection is the payload itself; beans missing from a
+// payload that was fetched are logged here and the outcome is kept for
+// /debug/targets.
+func SourceMetrics(cs ...*Collection) string {
+	ret := ""
+	for _, c := range cs {
+		recordCollection(c)
+		for _, source := range c.Sources {
+			key := c.Target + " " + source
+			up := 0
+			if c.Up[source] {
+				up = 1
+			} else {
+				g_sourceErrors[key]++
+				if c.Up[c.Sources[0]] {
+					LogAt(LevelWarn, "bean not found", "target", c.Target, "bean", source)
+				}
+			}
+			g_decodeErrors[key] += float64(len(c.Failed[source]))
+
+			ret += fmt.Sprintf("hadoop_exporter_source_up{source=\"%s\",role=\"%s\"} %d\n",
+				source, g_role, up)
+			ret += fmt.Sprintf("hadoop_exporter_source_errors_total{source=\"%s\",role=\"%s\"} %g\n",
+				source, g_role, g_sourceErrors[key])
+			ret += fmt.Sprintf("hadoop_exporter_decode_errors_total{source=\"%s\",role=\"%s\"} %g\n",
+				source, g_role, g_decodeErrors[key])
+		}
+	}
+	return ret
+}
+
+// Parallel runs fns concurrently, at most limit at a time, and waits for all
+// of them to return. A panicking fn is stopped without taking the others
+// down; its source simply stays unreported for this cycle.
+func Parallel(limit int, fns ...func()) {
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for _, fn := range fns {
+		wg.Add(1)
+		go func(fn func()) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			defer func() {
+				if r := recover(); r != nil {
+					LogAt(LevelError, "collector panicked", "panic", r)
+				}
+			}()
+			fn()
+		}(fn)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var g_debug = map[string]*targetDebug{}
+var g_debugLock sync.Mutex
+
+// targetDebug is what /debug/targets shows about the last fetch of a target.
+type targetDebug struct {
+	fetched time.Time
+	status  int
+	latency time.Duration
+	err     string
+	body    string
+	found   []string
+	missing []string
+	failed  map[string][]string
+}
+
+func debugFor(target string) *targetDebug {
+	d := g_debug[target]
+	if d == nil {
+		d = &targetDebug{}
+		g_debug[target] = d
+	}
+	return d
+}
+
+func recordFetch(target string, start time.Time, status int, errs []error, body string) {
+	g_debugLock.Lock()
+	defer g_debugLock.Unlock()
+	d := debugFor(target)
+	d.fetched = start
+	d.latency = time.Since(start)
+	d.status = status
+	d.err = ""
+	if errs != nil {
+		d.err = fmt.Sprint(errs)
+	}
+	d.body = body
+}
+
+func recordCollection(c *Collection) {
+	g_debugLock.Lock()
+	defer g_debugLock.Unlock()
+	d := debugFor(c.Target)
+	d.found, d.missing = nil, nil
+	for _, source := range c.Sources {
+		if c.Up[source] {
+			d.found = append(d.found, source)
+		} else {
+			d.missing = append(d.missing, source)
+		}
+	}
+	d.failed = c.Failed
+}
+
+// DebugTargets lists the last fetch of every target along with the sources
+// found in it. With ?target=<url>&raw=1 it serves the last raw response of
+// that target as a download instead.
+func DebugTargets(w http.ResponseWriter, r *http.Request) {
+	g_debugLock.Lock()
+	defer g_debugLock.Unlock()
+
+	if target := r.FormValue("target"); target != "" && r.FormValue("raw") != "" {
+		d, ok := g_debug[target]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+g_name+".json\"")
+		io.WriteString(w, d.body)
+		return
+	}
+
+	var targets []string
+	for target := range g_debug {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	g_breakerLock.Lock()
+	defer g_breakerLock.Unlock()
+	ret := "<html><head><title>Targets</title></head><body><h1>Targets</h1>\n"
+	for _, target := range targets {
+		d := g_debug[target]
+		breaker := "closed"
+		if b := g_breakers[target]; b != nil && time.Now().Before(b.openUntil) {
+			breaker = "open until " + b.openUntil.Format(time.RFC3339)
+		}
+		var failed []string
+		for source, attrs := range d.failed {
+			failed = append(failed, source+": "+strings.Join(attrs, ", "))
+		}
+		sort.Strings(failed)
+
+		ret += fmt.Sprintf("<h2>%s</h2>\n<table>\n", html.EscapeString(target))
+		for _, row := range [][2]string{
+			{"Last fetch", d.fetched.Format(time.RFC3339)},
+			{"HTTP status", strconv.Itoa(d.status)},
+			{"Latency", d.latency.String()},
+			{"Payload size", strconv.Itoa(len(d.body)) + " bytes"},
+			{"Error", d.err},
+			{"Circuit breaker", breaker},
+			{"Found", strings.Join(d.found, ", ")},
+			{"Missing", strings.Join(d.missing, ", ")},
+			{"Undecodable attributes", strings.Join(failed, "; ")},
+		} {
+			ret += fmt.Sprintf("<tr><th align=\"left\">%s</th><td>%s</td></tr>\n",
+				row[0], html.EscapeString(row[1]))
+		}
+		ret += fmt.Sprintf("</table>\n<p><a href=\"?target=%s&raw=1\">Last raw response</a></p>\n",
+			url.QueryEscape(target))
+	}
+	ret += "</body></html>\n"
+	io.WriteString(w, ret)
+}
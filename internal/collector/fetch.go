@@ -0,0 +1,161 @@
+package collector
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/parnurzeal/gorequest"
+)
+
+var g_breakers = map[string]*circuitBreaker{}
+var g_breakerLock sync.Mutex
+
+// circuitBreaker tracks the fetch health of one target URL.
+type circuitBreaker struct {
+	failures  int
+	openUntil time.Time
+	opens     float64
+	retries   float64
+}
+
+func BreakerMetrics() string {
+	g_breakerLock.Lock()
+	defer g_breakerLock.Unlock()
+
+	var targets []string
+	for target := range g_breakers {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	ret := ""
+	for _, target := range targets {
+		b := g_breakers[target]
+		open := 0
+		if time.Now().Before(b.openUntil) {
+			open = 1
+		}
+		ret += fmt.Sprintf("hadoop_exporter_circuit_breaker_open{target=\"%s\",role=\"%s\"} %d\n",
+			target, g_role, open)
+		ret += fmt.Sprintf("hadoop_exporter_circuit_breaker_opens_total{target=\"%s\",role=\"%s\"} %g\n",
+			target, g_role, b.opens)
+		ret += fmt.Sprintf("hadoop_exporter_fetch_consecutive_failures{target=\"%s\",role=\"%s\"} %d\n",
+			target, g_role, b.failures)
+		ret += fmt.Sprintf("hadoop_exporter_fetch_retries_total{target=\"%s\",role=\"%s\"} %g\n",
+			target, g_role, b.retries)
+	}
+	return ret
+}
+
+// retryable reports whether a failed request is worth another attempt:
+// connection errors, timeouts, throttling and server-side errors are.
+func retryable(resp gorequest.Response, errs []error) bool {
+	if errs != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= http.StatusInternalServerError
+}
+
+// Fetch GETs target and returns the response body. Failed requests are retried
+// with exponential backoff and jitter; once a target has failed
+// breakerThreshold times in a row its circuit breaker opens and the target
+// is left alone for breakerCooldown. The first fetch after the cooldown is a
+// single probe without retries: a success closes the breaker, a failure opens
+// it again.
+func Fetch(target string) (string, bool) {
+	body, _, ok := fetch(target, target, false)
+	return body, ok
+}
+
+// FetchFrom GETs target like Fetch, but keeps the circuit breaker and the
+// /debug/targets record under key, e.g. the base URL of an API queried for
+// many paths. A 4xx answer other than 429 concerns the request rather than
+// the server: it is returned with its status, without retries and without
+// counting against the breaker.
+func FetchFrom(key, target string) (string, int, bool) {
+	return fetch(key, target, true)
+}
+
+func fetch(key, target string, clientErrors bool) (string, int, bool) {
+	// context of log lines, naming the URL too where it differs from key.
+	context := func(kv ...interface{}) []interface{} {
+		ret := []interface{}{"target", key}
+		if target != key {
+			ret = append(ret, "url", target)
+		}
+		return append(ret, kv...)
+	}
+
+	g_breakerLock.Lock()
+	b := g_breakers[key]
+	if b == nil {
+		b = &circuitBreaker{}
+		g_breakers[key] = b
+	}
+	open := time.Now().Before(b.openUntil)
+	probe := b.failures >= *breakerThreshold
+	g_breakerLock.Unlock()
+	if open {
+		LogAt(LevelDebug, "circuit breaker open, target skipped", context()...)
+		return "", 0, false
+	}
+
+	backoff := *fetchBackoff
+	status := 0
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		resp, body, errs := gorequest.New().Timeout(*fetchTimeout).Get(target).End()
+		status = 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		recordFetch(key, start, status, errs, body)
+		if errs == nil && status == http.StatusOK {
+			g_breakerLock.Lock()
+			if b.failures >= *breakerThreshold {
+				LogAt(LevelInfo, "circuit breaker closed", "target", key)
+			}
+			b.failures = 0
+			g_breakerLock.Unlock()
+			return body, status, true
+		}
+		if clientErrors && errs == nil && status >= http.StatusBadRequest &&
+			status < http.StatusInternalServerError && status != http.StatusTooManyRequests {
+			LogAt(LevelDebug, "request rejected", context("status", status)...)
+			return body, status, false
+		}
+
+		if attempt >= *fetchRetries || probe || !retryable(resp, errs) {
+			LogAt(LevelError, "fetch failed", context("attempts", attempt+1,
+				"status", status, "err", errs)...)
+			break
+		}
+		LogAt(LevelDebug, "fetch attempt failed, retrying", context("attempt", attempt+1,
+			"status", status, "err", errs)...)
+
+		g_breakerLock.Lock()
+		b.retries++
+		g_breakerLock.Unlock()
+		time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)))
+		backoff *= 2
+		if backoff > *fetchMaxBackoff {
+			backoff = *fetchMaxBackoff
+		}
+	}
+
+	g_breakerLock.Lock()
+	b.failures++
+	if b.failures >= *breakerThreshold {
+		b.openUntil = time.Now().Add(*breakerCooldown)
+		b.opens++
+		LogAt(LevelWarn, "circuit breaker opened", "target", key, "failures", b.failures,
+			"cooldown", *breakerCooldown)
+	}
+	g_breakerLock.Unlock()
+	return "", status, false
+}
@@ -0,0 +1,195 @@
+package collector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	g_logLevel = LevelError + 1
+	os.Exit(m.Run())
+}
+
+// fakeTarget answers the requests it gets with statuses in turn, repeating
+// the last one, and counts them.
+type fakeTarget struct {
+	sync.Mutex
+	statuses []int
+	hits     int
+}
+
+func (f *fakeTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	status := f.statuses[len(f.statuses)-1]
+	if f.hits < len(f.statuses) {
+		status = f.statuses[f.hits]
+	}
+	f.hits++
+	w.WriteHeader(status)
+	w.Write([]byte("body"))
+}
+
+func (f *fakeTarget) count() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.hits
+}
+
+// setFlags sets the fetch and breaker flags and returns a func restoring
+// them.
+func setFlags(retries, threshold int, backoff, maxBackoff, cooldown time.Duration) func() {
+	r, th, b, mb, c := *fetchRetries, *breakerThreshold, *fetchBackoff, *fetchMaxBackoff, *breakerCooldown
+	*fetchRetries, *breakerThreshold = retries, threshold
+	*fetchBackoff, *fetchMaxBackoff, *breakerCooldown = backoff, maxBackoff, cooldown
+	return func() {
+		*fetchRetries, *breakerThreshold = r, th
+		*fetchBackoff, *fetchMaxBackoff, *breakerCooldown = b, mb, c
+	}
+}
+
+// targetURL names a path of srv unique to the test, as breakers are kept per
+// URL and a later server may get the port of an earlier one.
+func targetURL(t *testing.T, srv *httptest.Server, name string) string {
+	return srv.URL + "/" + url.PathEscape(t.Name()+"/"+name)
+}
+
+func breakerOf(target string) circuitBreaker {
+	g_breakerLock.Lock()
+	defer g_breakerLock.Unlock()
+	return *g_breakers[target]
+}
+
+func TestFetchRetries(t *testing.T) {
+	defer setFlags(3, 10, time.Millisecond, time.Millisecond, time.Minute)()
+	for _, test := range []struct {
+		name         string
+		statuses     []int
+		wantOK       bool
+		wantHits     int
+		wantFailures int
+	}{
+		{"success", []int{200}, true, 1, 0},
+		{"recovers", []int{500, 503, 200}, true, 3, 0},
+		{"throttled", []int{429, 200}, true, 2, 0},
+		{"retries exhausted", []int{500}, false, 4, 1},
+		{"not retryable", []int{404}, false, 1, 1},
+	} {
+		f := &fakeTarget{statuses: test.statuses}
+		srv := httptest.NewServer(f)
+		target := targetURL(t, srv, test.name)
+		body, ok := Fetch(target)
+		srv.Close()
+
+		if ok != test.wantOK || ok && body != "body" {
+			t.Errorf("%s: Fetch = %q, %v, want ok %v", test.name, body, ok, test.wantOK)
+		}
+		if f.count() != test.wantHits {
+			t.Errorf("%s: %d requests, want %d", test.name, f.count(), test.wantHits)
+		}
+		b := breakerOf(target)
+		if b.failures != test.wantFailures {
+			t.Errorf("%s: %d consecutive failures, want %d", test.name, b.failures, test.wantFailures)
+		}
+		if int(b.retries) != test.wantHits-1 {
+			t.Errorf("%s: %g retries, want %d", test.name, b.retries, test.wantHits-1)
+		}
+	}
+}
+
+func TestFetchBackoff(t *testing.T) {
+	defer setFlags(3, 10, time.Millisecond, time.Millisecond, time.Minute)()
+	for _, test := range []struct {
+		name       string
+		backoff    time.Duration
+		maxBackoff time.Duration
+		// Bounds of the total delay of three retries, given a jitter of up
+		// to half of each delay.
+		min, max time.Duration
+	}{
+		{"doubled", 20 * time.Millisecond, time.Second, 70 * time.Millisecond, 140 * time.Millisecond},
+		{"capped", 20 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 60 * time.Millisecond},
+	} {
+		*fetchBackoff, *fetchMaxBackoff = test.backoff, test.maxBackoff
+		srv := httptest.NewServer(&fakeTarget{statuses: []int{500}})
+		start := time.Now()
+		Fetch(targetURL(t, srv, test.name))
+		elapsed := time.Since(start)
+		srv.Close()
+
+		// The upper bound leaves room for the requests themselves.
+		if elapsed < test.min || elapsed > test.max+200*time.Millisecond {
+			t.Errorf("%s: retries took %v, want %v to %v", test.name, elapsed, test.min, test.max)
+		}
+	}
+}
+
+func TestFetchFromClientErrors(t *testing.T) {
+	defer setFlags(3, 1, time.Millisecond, time.Millisecond, time.Minute)()
+	f := &fakeTarget{statuses: []int{404}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	base := targetURL(t, srv, "")
+	body, status, ok := FetchFrom(base, base+"/missing")
+	if ok || status != http.StatusNotFound || body != "body" {
+		t.Errorf("FetchFrom = %q, %d, %v, want the 404 answer", body, status, ok)
+	}
+	if f.count() != 1 {
+		t.Errorf("%d requests, want 1", f.count())
+	}
+	if b := breakerOf(base); b.failures != 0 {
+		t.Errorf("%d consecutive failures, want none", b.failures)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	defer setFlags(2, 2, time.Millisecond, time.Millisecond, 50*time.Millisecond)()
+	for _, test := range []struct {
+		name string
+		// Status the probe after the cooldown is answered with.
+		probe    int
+		wantOK   bool
+		wantOpen bool
+	}{
+		{"probe succeeds", 200, true, false},
+		{"probe fails", 500, false, true},
+	} {
+		f := &fakeTarget{statuses: []int{500}}
+		srv := httptest.NewServer(f)
+		target := targetURL(t, srv, test.name)
+
+		Fetch(target)
+		Fetch(target)
+		if f.count() != 6 {
+			t.Errorf("%s: %d requests before the breaker opened, want 6", test.name, f.count())
+		}
+		if _, ok := Fetch(target); ok || f.count() != 6 {
+			t.Errorf("%s: open breaker let a request through", test.name)
+		}
+
+		time.Sleep(60 * time.Millisecond)
+		f.Lock()
+		f.statuses = []int{test.probe}
+		f.hits = 0
+		f.Unlock()
+		_, ok := Fetch(target)
+		srv.Close()
+
+		if ok != test.wantOK {
+			t.Errorf("%s: probe ok %v, want %v", test.name, ok, test.wantOK)
+		}
+		if f.count() != 1 {
+			t.Errorf("%s: probe made %d requests, want a single one", test.name, f.count())
+		}
+		b := breakerOf(target)
+		if open := time.Now().Before(b.openUntil); open != test.wantOpen {
+			t.Errorf("%s: breaker open %v after the probe, want %v", test.name, open, test.wantOpen)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	LevelDebug int32 = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+var logFormats = []string{"logfmt", "json"}
+
+var g_logLevel int32
+var g_logLock sync.Mutex
+var g_logSeen = map[string]*logEntry{}
+
+// logEntry remembers when a log line was last written and how many
+// identical lines have been dropped since.
+type logEntry struct {
+	last       time.Time
+	suppressed int
+}
+
+func parseLevel(s string) (int32, bool) {
+	for i, name := range levelNames {
+		if s == name {
+			return int32(i), true
+		}
+	}
+	return 0, false
+}
+
+// LogAt writes one structured line to stderr if level is enabled. kv holds
+// alternating keys and values giving the context of the message. A line
+// repeating the level, message, target and bean of one written less than
+// logRateLimit ago is dropped; the next one written reports how many were.
+// Other context such as attempts or values is left out of the comparison, as
+// it often changes between lines about the same problem.
+func LogAt(level int32, msg string, kv ...interface{}) {
+	if level < atomic.LoadInt32(&g_logLevel) {
+		return
+	}
+
+	now := time.Now()
+	key := logKey(level, msg, kv)
+	g_logLock.Lock()
+	defer g_logLock.Unlock()
+	e := g_logSeen[key]
+	if e != nil && now.Sub(e.last) < *logRateLimit {
+		e.suppressed++
+		return
+	}
+	if len(g_logSeen) > 1000 {
+		for k, old := range g_logSeen {
+			if now.Sub(old.last) >= *logRateLimit {
+				delete(g_logSeen, k)
+			}
+		}
+	}
+	fields := append([]interface{}{
+		"ts", now.Format(time.RFC3339Nano), "level", levelNames[level], "msg", msg,
+	}, kv...)
+	if e != nil && e.suppressed > 0 {
+		fields = append(fields, "suppressed", e.suppressed)
+	}
+	g_logSeen[key] = &logEntry{last: now}
+
+	line := ""
+	for i := 0; i+1 < len(fields); i += 2 {
+		k, v := fmt.Sprint(fields[i]), fmt.Sprint(fields[i+1])
+		if *logFormat == "json" {
+			kj, _ := json.Marshal(k)
+			vj, _ := json.Marshal(v)
+			line += "," + string(kj) + ":" + string(vj)
+		} else {
+			if strings.ContainsAny(v, " =\"\t\n") || v == "" {
+				v = strconv.Quote(v)
+			}
+			line += " " + k + "=" + v
+		}
+	}
+	if *logFormat == "json" {
+		line = "{" + line[1:] + "}"
+	} else {
+		line = line[1:]
+	}
+	os.Stderr.WriteString(line + "\n")
+}
+
+// logKey identifies a log line for rate limiting by its level, message and
+// the values of its target and bean keys.
+func logKey(level int32, msg string, kv []interface{}) string {
+	key := fmt.Sprint(level, " ", msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i] == "target" || kv[i] == "bean" {
+			key += fmt.Sprint(" ", kv[i], "=", kv[i+1])
+		}
+	}
+	return key
+}
+
+// LogLevel shows the current log level on GET and changes it on POST or PUT
+// with a level form value, e.g. curl -XPUT '.../log/level?level=debug'.
+func LogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		level, ok := parseLevel(r.FormValue("level"))
+		if !ok {
+			http.Error(w, "unknown log level, want one of "+strings.Join(levelNames, ", "),
+				http.StatusBadRequest)
+			return
+		}
+		atomic.StoreInt32(&g_logLevel, level)
+		LogAt(LevelInfo, "log level changed", "level", levelNames[level])
+	}
+	io.WriteString(w, levelNames[atomic.LoadInt32(&g_logLevel)]+"\n")
+}
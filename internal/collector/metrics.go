@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// percentileAttr matches the latency percentiles Hadoop adds to a bean for
+// every interval in rpc.metrics.percentiles.intervals or
+// dfs.metrics.percentiles.intervals, e.g. RpcQueueTime60s99thPercentileLatency.
+var percentileAttr = regexp.MustCompile(`^([A-Za-z]+)(\d+)s(\d+)thPercentileLatency$`)
+
+// Quantile is one percentile attribute of a bean. The <Name><Interval>NumOps
+// attribute next to them is kept as a Quantile with an empty Quantile.
+type Quantile struct {
+	Bean     string
+	Name     string
+	Interval string
+	Quantile string
+	Value    float64
+}
+
+// Quantiles finds the percentile attributes of bean.
+func Quantiles(bean map[string]interface{}) []Quantile {
+	beanName, _ := bean["name"].(string)
+	if i := strings.LastIndex(beanName, "name="); i >= 0 {
+		beanName = beanName[i+len("name="):]
+	}
+
+	var ret []Quantile
+	counted := map[string]bool{}
+	for attr, value := range bean {
+		m := percentileAttr.FindStringSubmatch(attr)
+		v, ok := value.(float64)
+		if m == nil || !ok {
+			continue
+		}
+		name, interval := m[1], m[2]+"s"
+		percentile, _ := strconv.ParseFloat(m[3], 64)
+		ret = append(ret, Quantile{Bean: beanName, Name: name, Interval: interval,
+			Quantile: strconv.FormatFloat(percentile/100, 'g', -1, 64), Value: v})
+
+		count, ok := bean[name+interval+"NumOps"].(float64)
+		if ok && !counted[name+interval] {
+			counted[name+interval] = true
+			ret = append(ret, Quantile{Bean: beanName, Name: name, Interval: interval, Value: count})
+		}
+	}
+	return ret
+}
+
+// QuantileMetrics renders percentiles as <name>_latency, one per bean and
+// name, told apart by interval and quantile labels. The number of operations
+// within the interval goes to the <name>_interval_ops gauge: it covers the
+// last interval only, so it is no summary count.
+func QuantileMetrics(qs []Quantile) string {
+	sort.Slice(qs, func(i, j int) bool {
+		a, b := qs[i], qs[j]
+		if a.Bean != b.Bean {
+			return a.Bean < b.Bean
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Interval != b.Interval {
+			return a.Interval < b.Interval
+		}
+		return a.Quantile < b.Quantile
+	})
+
+	ret := ""
+	nameSpace := "hadoop_"
+	for _, q := range qs {
+		if q.Quantile == "" {
+			ret += fmt.Sprintf("%s_%s_interval_ops{bean=\"%s\",interval=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, SnakeCase(q.Name), q.Bean, q.Interval, g_role, q.Value)
+		} else {
+			ret += fmt.Sprintf("%s_%s_latency{bean=\"%s\",interval=\"%s\",quantile=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, SnakeCase(q.Name), q.Bean, q.Interval, q.Quantile, g_role, q.Value)
+		}
+	}
+	return ret
+}
+
+// Runtime is what the JVM of the daemon reports about itself.
+type Runtime struct {
+	StartTime  float64
+	Uptime     float64
+	JvmVersion string
+}
+
+// RuntimeInfo reads the java.lang:type=Runtime bean. The JVM version is
+// taken from the java.version system property, or VmVersion without it.
+func RuntimeInfo(c *Collection, bean map[string]interface{}) Runtime {
+	get := c.Bean("Runtime", bean)
+	ret := Runtime{StartTime: get("StartTime"), Uptime: get("Uptime")}
+	ret.JvmVersion, _ = bean["VmVersion"].(string)
+	props, _ := bean["SystemProperties"].([]interface{})
+	for _, prop := range props {
+		kv, _ := prop.(map[string]interface{})
+		if v, ok := kv["value"].(string); ok && kv["key"] == "java.version" {
+			ret.JvmVersion = v
+		}
+	}
+	return ret
+}
+
+// RuntimeMetrics renders when the JVM started and how long it has run.
+func RuntimeMetrics(r Runtime) string {
+	ret := ""
+	nameSpace := "hadoop_"
+	ret += fmt.Sprintf("%s_start_time_seconds{role=\"%s\"} %g\n",
+		nameSpace, g_role, r.StartTime/1000)
+	ret += fmt.Sprintf("%s_uptime_seconds{role=\"%s\"} %g\n",
+		nameSpace, g_role, r.Uptime/1000)
+	return ret
+}
+
+// SplitVersion splits a Hadoop version like "3.3.6, r1be78238728d" into the
+// version and the revision it was built from.
+func SplitVersion(v string) (string, string) {
+	if i := strings.Index(v, ", r"); i >= 0 {
+		return v[:i], v[i+len(", r"):]
+	}
+	return v, ""
+}
+
+// BuildInfo renders build_info with the given alternating label names and
+// values.
+func BuildInfo(kv ...string) string {
+	labels := ""
+	for i := 0; i+1 < len(kv); i += 2 {
+		labels += fmt.Sprintf("%s=\"%s\",", kv[i], EscapeLabel(kv[i+1]))
+	}
+	nameSpace := "hadoop_"
+	return fmt.Sprintf("%s_build_info{%srole=\"%s\"} 1\n", nameSpace, labels, g_role)
+}
+
+// EscapeLabel escapes s for use as a label value.
+func EscapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// SnakeCase turns a JMX attribute name like NumOpenConnections into
+// num_open_connections.
+func SnakeCase(s string) string {
+	ret := ""
+	for i, r := range s {
+		if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(rune(s[i-1])) {
+			ret += "_"
+		}
+		ret += string(unicode.ToLower(r))
+	}
+	return ret
+}
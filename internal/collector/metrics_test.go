@@ -0,0 +1,93 @@
+package collector
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestQuantiles(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		bean map[string]interface{}
+		want []Quantile
+	}{
+		{
+			"percentiles with count",
+			map[string]interface{}{
+				"name":                                 "Hadoop:service=NameNode,name=RpcActivityForPort8020",
+				"RpcQueueTime60s50thPercentileLatency": 1.0,
+				"RpcQueueTime60s99thPercentileLatency": 5.0,
+				"RpcQueueTime60sNumOps":                10.0,
+				"CallQueueLength":                      3.0,
+			},
+			[]Quantile{
+				{Bean: "RpcActivityForPort8020", Name: "RpcQueueTime", Interval: "60s", Value: 10},
+				{Bean: "RpcActivityForPort8020", Name: "RpcQueueTime", Interval: "60s", Quantile: "0.5", Value: 1},
+				{Bean: "RpcActivityForPort8020", Name: "RpcQueueTime", Interval: "60s", Quantile: "0.99", Value: 5},
+			},
+		},
+		{
+			"intervals apart, no count",
+			map[string]interface{}{
+				"name":                                 "Hadoop:service=DataNode,name=DataNodeActivity-dn1-9866",
+				"FlushNanos300s75thPercentileLatency":  7.0,
+				"FlushNanos3600s75thPercentileLatency": 8.0,
+			},
+			[]Quantile{
+				{Bean: "DataNodeActivity-dn1-9866", Name: "FlushNanos", Interval: "300s", Quantile: "0.75", Value: 7},
+				{Bean: "DataNodeActivity-dn1-9866", Name: "FlushNanos", Interval: "3600s", Quantile: "0.75", Value: 8},
+			},
+		},
+		{
+			"not numeric",
+			map[string]interface{}{
+				"name":                                 "Hadoop:service=NameNode,name=RpcActivityForPort8020",
+				"RpcQueueTime60s99thPercentileLatency": "5",
+				"RpcQueueTime60sNumOps":                10.0,
+			},
+			nil,
+		},
+	} {
+		got := Quantiles(test.bean)
+		sort.Slice(got, func(i, j int) bool {
+			if got[i].Interval != got[j].Interval {
+				return got[i].Interval < got[j].Interval
+			}
+			return got[i].Quantile < got[j].Quantile
+		})
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Quantiles = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestQuantileMetrics(t *testing.T) {
+	defer func(role string) { g_role = role }(g_role)
+	g_role = "NameNode"
+
+	for _, test := range []struct {
+		name string
+		qs   []Quantile
+		want string
+	}{
+		{"none", nil, ""},
+		{
+			"sorted by bean, name, interval and quantile",
+			[]Quantile{
+				{Bean: "RpcActivityForPort8020", Name: "RpcQueueTime", Interval: "60s", Quantile: "0.99", Value: 5},
+				{Bean: "RpcActivityForPort8020", Name: "RpcProcessingTime", Interval: "60s", Quantile: "0.5", Value: 2},
+				{Bean: "RpcActivityForPort8020", Name: "RpcQueueTime", Interval: "60s", Value: 10},
+				{Bean: "RpcActivityForPort8020", Name: "RpcQueueTime", Interval: "60s", Quantile: "0.5", Value: 1},
+			},
+			"hadoop__rpc_processing_time_latency{bean=\"RpcActivityForPort8020\",interval=\"60s\",quantile=\"0.5\",role=\"NameNode\"} 2\n" +
+				"hadoop__rpc_queue_time_interval_ops{bean=\"RpcActivityForPort8020\",interval=\"60s\",role=\"NameNode\"} 10\n" +
+				"hadoop__rpc_queue_time_latency{bean=\"RpcActivityForPort8020\",interval=\"60s\",quantile=\"0.5\",role=\"NameNode\"} 1\n" +
+				"hadoop__rpc_queue_time_latency{bean=\"RpcActivityForPort8020\",interval=\"60s\",quantile=\"0.99\",role=\"NameNode\"} 5\n",
+		},
+	} {
+		if got := QuantileMetrics(test.qs); got != test.want {
+			t.Errorf("%s: QuantileMetrics =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
@@ -6,12 +6,19 @@ import (
 	"os"
 	"net/http"
 	"io"
-	"github.com/parnurzeal/gorequest"
 	"github.com/robfig/cron"
 	"time"
 	"fmt"
 	"sync"
 	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"net/url"
+	"regexp"
+	"path"
+
+	"github.com/ximply/hadoop_exporter/internal/collector"
 )
 
 var (
@@ -20,18 +27,112 @@ var (
 	metricsPath    = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
 	nameNodeJmxUrl = flag.String("jmx.url", "http://localhost:50070/jmx", "Hadoop namenode JMX URL.")
 	role           = flag.String("role", "NameNode", "Role type.")
+	nameServiceUrls  = flag.String("nameservice.urls", "", "Comma separated JMX URLs of all NameNodes of a nameservice, each optionally prefixed by its nn_id, e.g. nn1=http://nn1:50070/jmx,nn2=http://nn2:50070/jmx. Overrides -jmx.url.")
+	concurrency      = flag.Int("collect.concurrency", 4, "Maximum number of sources fetched at the same time.")
+	topUsers         = flag.Int("nntop.top-users", 10, "Number of top users exported per nntop window and operation.")
+	rpcTopCallers    = flag.Int("rpc.top-callers", 10, "Number of callers with the highest call volume exported per RPC port from DecayRpcScheduler.")
+	rpcMethods       = flag.String("rpc.methods", "", "Comma separated RPC methods exported from RpcDetailedActivity, e.g. getBlockLocations,create,addBlock. Empty exports all of them.")
+	quotaPaths       = flag.String("quota.paths", "", "Comma separated HDFS directories or globs, e.g. /user/*, whose quota and usage are exported through WebHDFS of the active NameNode.")
+	webhdfsUser      = flag.String("webhdfs.user", "", "User name WebHDFS requests are made as, if set.")
 )
 
 var g_doing bool
 var g_ret string
 var g_lock sync.RWMutex
 
+// HA state of every NameNode seen in the previous cycle, to count
+// transitions between cycles.
+var g_haState = map[string]string{}
+var g_haLastTransition = map[string]float64{}
+var g_haTransitions = map[string]float64{}
+
+// JVM start time of every NameNode seen in the previous cycle, to tell a
+// restart from a failover.
+var g_haStartTime = map[string]float64{}
+
+// Safe mode state of every NameNode seen in the previous cycle, to log
+// when a NameNode enters or leaves safe mode.
+var g_safeMode = map[string]string{}
+
+// Progress of the DataNodes leaving service, by state and hostname.
+var g_outOfService = map[string]*outOfServiceProgress{}
+
+// Whether the NameNode behind a WebHDFS base URL knows GETQUOTAUSAGE, which
+// came with Hadoop 3. Absent until the NameNode first answered it.
+var g_quotaUsage = map[string]bool{}
+
 type HadoopNameNodeJmxInfo struct {
 	FSNamesystemInfo FSNamesystem
 	MemoryInfo Memory
 	FSNamesystemStateInfo FSNamesystemState
 	NameNodeActivityInfo NameNodeActivity
 	JvmMetricsInfo JvmMetrics
+	NameNodeStatusInfo NameNodeStatus
+	NameNodeInfo NameNodeInfo
+	RpcActivityInfo []RpcActivity
+	RpcDetailedActivityInfo []RpcDetailedActivity
+	Quantiles []collector.Quantile
+	RpcSchedulers map[string]*RpcScheduler
+	StartupProgressInfo StartupProgress
+	StorageTypeStats []StorageTypeStats
+	BlocksStates []BlocksState
+	RuntimeInfo collector.Runtime
+	SnapshottableDirectories []SnapshottableDirectory
+}
+
+// SnapshottableDirectory is a directory snapshots are allowed of, as listed
+// by the SnapshotInfo bean.
+type SnapshottableDirectory struct {
+	Path           string
+	SnapshotNumber float64
+	SnapshotQuota  float64
+}
+
+// BlocksState is the health of one kind of blocks on Hadoop 3, where
+// replicated blocks and erasure-coded block groups are counted apart.
+type BlocksState struct {
+	Kind   string
+	Counts map[string]float64
+}
+
+// blocksStateAttrs maps the attributes of the ReplicatedBlocksState and
+// ECBlockGroupsState beans to the type label they are exported with.
+var blocksStateAttrs = []struct {
+	bean  string
+	kind  string
+	attrs [][2]string
+}{
+	{"ReplicatedBlocksState", "replicated", [][2]string{
+		{"TotalReplicatedBlocks", "total"},
+		{"LowRedundancyReplicatedBlocks", "low_redundancy"},
+		{"HighestPriorityLowRedundancyReplicatedBlocks", "highest_priority_low_redundancy"},
+		{"CorruptReplicatedBlocks", "corrupt"},
+		{"MissingReplicatedBlocks", "missing"},
+		{"MissingReplicationOneBlocks", "missing_replication_one"},
+		{"PendingDeletionReplicatedBlocks", "pending_deletion"},
+		{"BytesInFutureReplicatedBlocks", "bytes_in_future"},
+	}},
+	{"ECBlockGroupsState", "ec", [][2]string{
+		{"TotalECBlockGroups", "total"},
+		{"LowRedundancyECBlockGroups", "low_redundancy"},
+		{"HighestPriorityLowRedundancyECBlocks", "highest_priority_low_redundancy"},
+		{"CorruptECBlockGroups", "corrupt"},
+		{"MissingECBlockGroups", "missing"},
+		{"PendingDeletionECBlocks", "pending_deletion"},
+		{"BytesInFutureECBlockGroups", "bytes_in_future"},
+	}},
+}
+
+// StorageTypeStats is the capacity of one storage type, e.g. DISK or SSD,
+// across the DataNodes in service.
+type StorageTypeStats struct {
+	StorageType        string
+	CapacityTotal      float64
+	CapacityUsed       float64
+	CapacityRemaining  float64
+	CapacityNonDfsUsed float64
+	BlockPoolUsed      float64
+	NodesInService     float64
 }
 
 type FSNamesystem struct {
@@ -46,6 +147,12 @@ type FSNamesystem struct {
 	TotalLoad                float64
 	ScheduledReplicationBlocks float64
 	PendingReplicationBlocks   float64
+	HAState                    string
+	TotalSyncTimes             []float64
+	LockHoldTimes              []LockHoldTime
+
+	// Attributes only some Hadoop versions have, by attribute name.
+	Optional map[string]float64
 }
 
 type Memory struct {
@@ -67,6 +174,25 @@ type FSNamesystemState struct {
 	ScheduledReplicationBlocks float64
 	NumLiveDataNodes float64
 	NumDeadDataNodes float64
+
+	// Attributes only some Hadoop versions have, by attribute name.
+	Optional map[string]float64
+
+	TopUserOpCounts []TopWindow
+}
+
+// TopWindow is one window of the top users per operation that the NameNode
+// keeps when dfs.namenode.top.enabled is on.
+type TopWindow struct {
+	WindowLenMs float64 `json:"windowLenMs"`
+	Ops         []struct {
+		OpType     string  `json:"opType"`
+		TotalCount float64 `json:"totalCount"`
+		TopUsers   []struct {
+			User  string  `json:"user"`
+			Count float64 `json:"count"`
+		} `json:"topUsers"`
+	} `json:"ops"`
 }
 
 type NameNodeActivity struct {
@@ -103,15 +229,269 @@ type NameNodeActivity struct {
 	GetImageAvgTime float64
 	PutImageNumOps float64
 	PutImageAvgTime float64
+
+	// Attributes only some Hadoop versions have, by attribute name.
+	Optional map[string]float64
+}
+
+// nameNodeActivityOps are the NameNodeActivity rates exported, each as far
+// as the Hadoop version has them, as <op>NumOps and <op>AvgTime.
+var nameNodeActivityOps = []string{
+	"EditLogTailTime", "EditLogFetchTime", "EditLogTailInterval", "NumEditLogLoaded",
+	"ResourceCheckTime",
+}
+
+// LockHoldTime is how long one operation held the FSNamesystem lock, as
+// reported with dfs.namenode.lock.detailed-metrics.enabled.
+type LockHoldTime struct {
+	Lock    string
+	Op      string
+	NumOps  float64
+	AvgTime float64
+}
+
+var lockAttr = regexp.MustCompile(`^FSN(Read|Write)Lock(\w+)Nanos(NumOps|AvgTime)$`)
+
+type StartupProgress struct {
+	ElapsedTime     float64
+	PercentComplete float64
+	Phases          []StartupPhase
+}
+
+// StartupPhase is the progress of one phase of NameNode startup. Count and
+// Total are in the unit of the phase's current step, e.g. inodes or edits.
+type StartupPhase struct {
+	Name            string
+	Count           float64
+	Total           float64
+	PercentComplete float64
+	ElapsedTime     float64
+}
+
+var startupPhases = []string{"LoadingFsImage", "LoadingEdits", "SavingCheckpoint", "SafeMode"}
+
+type NameNodeStatus struct {
+	State                string
+	LastHATransitionTime float64
+}
+
+type NameNodeInfo struct {
+	LiveNodes                []DataNodeStatus
+	DeadNodes                []DataNodeStatus
+	DecomNodes               []DataNodeStatus
+	EnteringMaintenanceNodes []DataNodeStatus
+
+	// JournalTransactionInfo by key, e.g. LastAppliedOrWrittenTxId.
+	JournalTransactionInfo map[string]float64
+
+	SafeMode SafeMode
+
+	// Build and identity of the NameNode and its namespace.
+	Version     string
+	Revision    string
+	CompileDate string
+	ClusterId   string
+	BlockPoolId string
+
+	// Outliers found by DataNode peer and disk latency tracking, if enabled.
+	SlowPeers          []SlowPeer
+	SlowDisks          []SlowDisk
+	HasSlowPeersReport bool
+	HasSlowDisksReport bool
+}
+
+// SlowPeer is one DataNode reporting another as slow in write pipelines.
+// Hadoop before 3.4 reports no latency.
+type SlowPeer struct {
+	SlowNode      string
+	ReportingNode string
+	Latency       float64
+	HasLatency    bool
+}
+
+// SlowDisk is the latency of one operation on a disk found to be slow.
+type SlowDisk struct {
+	Node    string
+	Disk    string
+	Op      string
+	Latency float64
+}
+
+// SafeMode is what the Safemode tip of NameNodeInfo tells. Threshold and
+// the block counts are only known in automatic safe mode.
+type SafeMode struct {
+	State          string
+	ReportedBlocks float64
+	NeededBlocks   float64
+	Threshold      float64
+	TotalBlocks    float64
+	HasThreshold   bool
+}
+
+var safeModeStates = []string{"off", "manual", "automatic", "resources_low"}
+
+var safeModeBlocks = regexp.MustCompile(`The reported blocks (\d+) (?:needs additional (\d+) blocks to reach|has reached) the threshold ([\d.]+) of total blocks (\d+)`)
+
+// safeMode parses the Safemode tip of NameNodeInfo, which is empty when the
+// NameNode is not in safe mode.
+func safeMode(tip string) SafeMode {
+	var ret SafeMode
+	switch {
+	case tip == "":
+		ret.State = "off"
+	case strings.Contains(tip, "Resources are low"):
+		ret.State = "resources_low"
+	case strings.Contains(tip, "turned on manually"):
+		ret.State = "manual"
+	default:
+		ret.State = "automatic"
+	}
+
+	if m := safeModeBlocks.FindStringSubmatch(tip); m != nil {
+		ret.HasThreshold = true
+		ret.ReportedBlocks, _ = strconv.ParseFloat(m[1], 64)
+		ret.NeededBlocks, _ = strconv.ParseFloat(m[2], 64)
+		ret.Threshold, _ = strconv.ParseFloat(m[3], 64)
+		ret.TotalBlocks, _ = strconv.ParseFloat(m[4], 64)
+	}
+	return ret
+}
+
+// DataNodeStatus is what the NameNode reports about one DataNode.
+type DataNodeStatus struct {
+	HostName       string
+	Rack           string
+	AdminState     string
+	LastContact    float64
+	Capacity       float64
+	Used           float64
+	Remaining      float64
+	NonDfsUsed     float64
+	NumBlocks      float64
+	VolumeFailures float64
+	XceiverCount   float64
+	HasXceivers    bool
+
+	UnderReplicatedBlocks      float64
+	DecommissionOnlyReplicas   float64
+	MaintenanceOnlyReplicas    float64
+	UnderReplicatedInOpenFiles float64
+}
+
+// outOfServiceProgress is where a DataNode stood when it was first seen
+// being decommissioned or entering maintenance.
+type outOfServiceProgress struct {
+	since  time.Time
+	blocks float64
+	seen   time.Time
+}
+
+// RpcActivity holds the RpcActivityForPort<port> bean of one RPC server.
+type RpcActivity struct {
+	Port   string
+	Values map[string]float64
+}
+
+// RpcDetailedActivity holds the RpcDetailedActivityForPort<port> bean of one
+// RPC server, by RPC method.
+type RpcDetailedActivity struct {
+	Port    string
+	NumOps  map[string]float64
+	AvgTime map[string]float64
+}
+
+// RpcScheduler holds what FairCallQueue and DecayRpcScheduler report about
+// the RPC server on one port, by caller and by priority level.
+type RpcScheduler struct {
+	CallerVolume         map[string]float64
+	CallerPriority       map[string]float64
+	PriorityResponseTime map[string]float64
+	PriorityCallVolume   map[string]float64
+	Values               map[string]float64
+	QueueSizes           []float64
+	OverflowedCalls      []float64
+}
+
+var ipcPort = regexp.MustCompile(`ipc\.(\d+)`)
+var callerAttr = regexp.MustCompile(`^Caller\((.*)\)\.(Volume|Priority)$`)
+var priorityAttr = regexp.MustCompile(`^Priority\.(\d+)\.(AvgResponseTime|CompletedCallVolume)$`)
+
+// rpcScheduler returns the RpcScheduler of the port in the bean name, adding
+// it to schedulers on first use.
+func rpcScheduler(schedulers map[string]*RpcScheduler, beanName string) *RpcScheduler {
+	port := ""
+	if m := ipcPort.FindStringSubmatch(beanName); m != nil {
+		port = m[1]
+	}
+	if schedulers[port] == nil {
+		schedulers[port] = &RpcScheduler{
+			CallerVolume:         map[string]float64{},
+			CallerPriority:       map[string]float64{},
+			PriorityResponseTime: map[string]float64{},
+			PriorityCallVolume:   map[string]float64{},
+			Values:               map[string]float64{},
+		}
+	}
+	return schedulers[port]
+}
+
+// decayRpcScheduler reads a DecayRpcSchedulerMetrics2 bean into r.
+func decayRpcScheduler(r *RpcScheduler, bean map[string]interface{}) {
+	for attr, value := range bean {
+		v, ok := value.(float64)
+		if !ok {
+			continue
+		}
+		if m := callerAttr.FindStringSubmatch(attr); m != nil && m[2] == "Volume" {
+			r.CallerVolume[m[1]] = v
+		} else if m != nil {
+			r.CallerPriority[m[1]] = v
+		} else if m := priorityAttr.FindStringSubmatch(attr); m != nil && m[2] == "AvgResponseTime" {
+			r.PriorityResponseTime[m[1]] = v
+		} else if m != nil {
+			r.PriorityCallVolume[m[1]] = v
+		}
+	}
+	for attr, v := range collector.Optional(bean, "CallVolume", "DecayedCallVolume", "UniqueCallers") {
+		r.Values[attr] = v
+	}
+}
+
+// floats returns v as a slice of numbers if it is a JSON array of them.
+func floats(v interface{}) []float64 {
+	values, _ := v.([]interface{})
+	var ret []float64
+	for _, value := range values {
+		f, ok := value.(float64)
+		if !ok {
+			return nil
+		}
+		ret = append(ret, f)
+	}
+	return ret
+}
+
+// rpcActivityAttrs are the RpcActivityForPort attributes exported, each as
+// far as the Hadoop version has it.
+var rpcActivityAttrs = []string{
+	"ReceivedBytes", "SentBytes",
+	"RpcQueueTimeNumOps", "RpcQueueTimeAvgTime",
+	"RpcProcessingTimeNumOps", "RpcProcessingTimeAvgTime",
+	"RpcLockWaitTimeNumOps", "RpcLockWaitTimeAvgTime",
+	"DeferredRpcProcessingTimeNumOps", "DeferredRpcProcessingTimeAvgTime",
+	"RpcAuthenticationFailures", "RpcAuthenticationSuccesses",
+	"RpcAuthorizationFailures", "RpcAuthorizationSuccesses",
+	"RpcClientBackoff", "RpcSlowCalls", "RpcRequeueCalls",
+	"NumOpenConnections", "NumDroppedConnections", "NumInProcessHandler",
+	"CallQueueLength",
 }
 
 type JvmMetrics struct {
 	GcTimeMillis float64
-	GcTimeMillisParNew float64
-	GcTimeMillisConcurrentMarkSweep float64
 	GcCount float64
-	GcCountParNew float64
-	GcCountConcurrentMarkSweep float64
+	// Per-collector GC times and counts, only reported by JVMs running the
+	// ParNew and CMS collectors.
+	GcCollectors map[string]float64
 	ThreadsBlocked float64
 	ThreadsWaiting float64
 }
@@ -121,271 +501,1557 @@ func metrics(w http.ResponseWriter, r *http.Request) {
 	g_lock.RLock()
 	io.WriteString(w, g_ret)
 	g_lock.RUnlock()
+	io.WriteString(w, collector.BreakerMetrics())
+}
+
+// rpcDetailedActivity collects the <Method>NumOps and <Method>AvgTime
+// attributes of a RpcDetailedActivityForPort bean for the methods allowed by
+// -rpc.methods.
+func rpcDetailedActivity(port string, bean map[string]interface{}) RpcDetailedActivity {
+	allowed := map[string]bool{}
+	for _, method := range strings.Split(*rpcMethods, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			allowed[method] = true
+		}
+	}
+
+	ret := RpcDetailedActivity{
+		Port:    port,
+		NumOps:  map[string]float64{},
+		AvgTime: map[string]float64{},
+	}
+	for attr, value := range bean {
+		v, ok := value.(float64)
+		if !ok || strings.HasPrefix(attr, "tag.") {
+			continue
+		}
+		var values map[string]float64
+		var method string
+		if strings.HasSuffix(attr, "NumOps") {
+			values, method = ret.NumOps, strings.TrimSuffix(attr, "NumOps")
+		} else if strings.HasSuffix(attr, "AvgTime") {
+			values, method = ret.AvgTime, strings.TrimSuffix(attr, "AvgTime")
+		} else {
+			continue
+		}
+		if method == "" {
+			continue
+		}
+		// Attributes are named after the method with its first letter upper-cased.
+		method = strings.ToLower(method[:1]) + method[1:]
+		if len(allowed) == 0 || allowed[method] {
+			values[method] = v
+		}
+	}
+	return ret
 }
 
-func info() (HadoopNameNodeJmxInfo, bool) {
+// lockHoldTimes collects the FSN<Read|Write>Lock<Op>Nanos rates of the
+// FSNamesystem bean, sorted by lock and operation.
+func lockHoldTimes(bean map[string]interface{}) []LockHoldTime {
+	byKey := map[string]*LockHoldTime{}
+	var keys []string
+	for attr, value := range bean {
+		m := lockAttr.FindStringSubmatch(attr)
+		v, ok := value.(float64)
+		if m == nil || !ok {
+			continue
+		}
+		key := m[1] + " " + m[2]
+		l := byKey[key]
+		if l == nil {
+			// Operations are named with their first letter upper-cased.
+			l = &LockHoldTime{Lock: strings.ToLower(m[1]), Op: strings.ToLower(m[2][:1]) + m[2][1:]}
+			byKey[key] = l
+			keys = append(keys, key)
+		}
+		if m[3] == "NumOps" {
+			l.NumOps = v
+		} else {
+			l.AvgTime = v
+		}
+	}
+	sort.Strings(keys)
+
+	var ret []LockHoldTime
+	for _, key := range keys {
+		ret = append(ret, *byKey[key])
+	}
+	return ret
+}
+
+// snapshottableDirectories decodes the SnapshottableDirectories attribute of
+// SnapshotInfo, sorted by path.
+func snapshottableDirectories(c *collector.Collection, v interface{}) []SnapshottableDirectory {
+	dirs, ok := v.([]interface{})
+	if !ok {
+		c.Fail("SnapshotInfo", "SnapshottableDirectories", v)
+		return nil
+	}
+
+	var ret []SnapshottableDirectory
+	for _, dir := range dirs {
+		d, _ := dir.(map[string]interface{})
+		path, _ := d["path"].(string)
+		number, ok1 := d["snapshotNumber"].(float64)
+		quota, ok2 := d["snapshotQuota"].(float64)
+		if path == "" || !ok1 || !ok2 {
+			c.Fail("SnapshotInfo", "SnapshottableDirectories", dir)
+			continue
+		}
+		ret = append(ret, SnapshottableDirectory{Path: path, SnapshotNumber: number, SnapshotQuota: quota})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret
+}
+
+// storageTypeStats decodes the StorageTypeStats attribute of BlockStats, a
+// list of {"key": <storage type>, "value": <stats>} entries.
+func storageTypeStats(c *collector.Collection, v interface{}) []StorageTypeStats {
+	entries, ok := v.([]interface{})
+	if !ok {
+		c.Fail("BlockStats", "StorageTypeStats", v)
+		return nil
+	}
+
+	var ret []StorageTypeStats
+	for _, entry := range entries {
+		e, _ := entry.(map[string]interface{})
+		storageType, _ := e["key"].(string)
+		stats, ok := e["value"].(map[string]interface{})
+		if storageType == "" || !ok {
+			c.Fail("BlockStats", "StorageTypeStats", entry)
+			continue
+		}
+		num := func(key string) float64 {
+			f, _ := stats[key].(float64)
+			return f
+		}
+		ret = append(ret, StorageTypeStats{
+			StorageType:        storageType,
+			CapacityTotal:      num("capacityTotal"),
+			CapacityUsed:       num("capacityUsed"),
+			CapacityRemaining:  num("capacityRemaining"),
+			CapacityNonDfsUsed: num("capacityNonDfsUsed"),
+			BlockPoolUsed:      num("blockPoolUsed"),
+			NodesInService:     num("nodesInService"),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].StorageType < ret[j].StorageType })
+	return ret
+}
+
+// slowPeers decodes the SlowPeersReport attribute of NameNodeInfo.
+func slowPeers(c *collector.Collection, raw string) ([]SlowPeer, bool) {
+	var report []struct {
+		SlowNode       string
+		ReportingNodes []string
+		// Hadoop 3.4 and later
+		SlowPeerLatencyWithReportingNodes []struct {
+			ReportingNode   string
+			ReportedLatency float64
+		}
+	}
+	if err := json.Unmarshal([]byte(raw), &report); err != nil {
+		c.Fail("NameNodeInfo", "SlowPeersReport", raw)
+		return nil, false
+	}
+
+	var ret []SlowPeer
+	for _, r := range report {
+		for _, node := range r.ReportingNodes {
+			ret = append(ret, SlowPeer{SlowNode: r.SlowNode, ReportingNode: node})
+		}
+		for _, l := range r.SlowPeerLatencyWithReportingNodes {
+			ret = append(ret, SlowPeer{SlowNode: r.SlowNode, ReportingNode: l.ReportingNode,
+				Latency: l.ReportedLatency, HasLatency: true})
+		}
+	}
+	return ret, true
+}
+
+// slowDisks decodes the SlowDisksReport attribute of NameNodeInfo. Disks are
+// identified as <datanode>:<disk>.
+func slowDisks(c *collector.Collection, raw string) ([]SlowDisk, bool) {
+	var report []struct {
+		SlowDiskID string
+		Latencies  map[string]float64
+	}
+	if err := json.Unmarshal([]byte(raw), &report); err != nil {
+		c.Fail("NameNodeInfo", "SlowDisksReport", raw)
+		return nil, false
+	}
+
+	var ret []SlowDisk
+	for _, r := range report {
+		node, disk := r.SlowDiskID, ""
+		if i := strings.LastIndex(node, ":"); i >= 0 {
+			node, disk = node[:i], node[i+1:]
+		}
+		var ops []string
+		for op := range r.Latencies {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		for _, op := range ops {
+			ret = append(ret, SlowDisk{Node: node, Disk: disk, Op: strings.ToLower(op),
+				Latency: r.Latencies[op]})
+		}
+	}
+	return ret, true
+}
+
+// journalTransactionInfo decodes the JournalTransactionInfo attribute of
+// NameNodeInfo, a JSON object of transaction ids given as strings.
+func journalTransactionInfo(c *collector.Collection, raw string) map[string]float64 {
+	var info map[string]string
+	if err := json.Unmarshal([]byte(raw), &info); err != nil {
+		c.Fail("NameNodeInfo", "JournalTransactionInfo", raw)
+		return nil
+	}
+	ret := map[string]float64{}
+	for key, value := range info {
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			ret[key] = v
+		}
+	}
+	return ret
+}
+
+// estimateCompletion returns the Unix time at which the DataNode tracked as
+// key should have no under-replicated blocks left, going by the rate they
+// went down at since it was first seen. ok is false until progress is seen.
+func estimateCompletion(key string, blocks float64, now time.Time) (float64, bool) {
+	p := g_outOfService[key]
+	if p == nil || blocks > p.blocks {
+		p = &outOfServiceProgress{since: now, blocks: blocks}
+		g_outOfService[key] = p
+	}
+	p.seen = now
+
+	done := p.blocks - blocks
+	elapsed := now.Sub(p.since).Seconds()
+	if done <= 0 || elapsed <= 0 {
+		return 0, false
+	}
+	return float64(now.Unix()) + blocks/(done/elapsed), true
+}
+
+// dataNodes decodes attr of the NameNodeInfo bean, a JSON-encoded object
+// holding the status of each DataNode by name, sorted by hostname.
+func dataNodes(c *collector.Collection, bean map[string]interface{}, attr string) []DataNodeStatus {
+	var nodes map[string]map[string]interface{}
+	raw, _ := bean[attr].(string)
+	if err := json.Unmarshal([]byte(raw), &nodes); err != nil {
+		c.Fail("NameNodeInfo", attr, raw)
+		return nil
+	}
+
+	var names []string
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ret []DataNodeStatus
+	for _, name := range names {
+		node := nodes[name]
+		num := func(key string) float64 {
+			f, _ := node[key].(float64)
+			return f
+		}
+		dn := DataNodeStatus{
+			HostName:       name,
+			LastContact:    num("lastContact"),
+			Capacity:       num("capacity"),
+			Used:           num("used"),
+			Remaining:      num("remaining"),
+			NonDfsUsed:     num("nonDfsUsedSpace"),
+			NumBlocks:      num("numBlocks"),
+			VolumeFailures: num("volfails"),
+
+			UnderReplicatedBlocks:      num("underReplicatedBlocks"),
+			DecommissionOnlyReplicas:   num("decommissionOnlyReplicas"),
+			MaintenanceOnlyReplicas:    num("maintenanceOnlyReplicas"),
+			UnderReplicatedInOpenFiles: num("underReplicateInOpenFiles"),
+		}
+		// Nodes are keyed by hostname:port.
+		if i := strings.LastIndex(name, ":"); i >= 0 {
+			dn.HostName = name[:i]
+		}
+		dn.Rack, _ = node["location"].(string)
+		dn.AdminState, _ = node["adminState"].(string)
+		dn.XceiverCount, dn.HasXceivers = node["xceiverCount"].(float64)
+		ret = append(ret, dn)
+	}
+	return ret
+}
+
+// adminStateLabel turns an admin state like "Decommission In Progress" into
+// the label value decommission_in_progress.
+func adminStateLabel(state string) string {
+	return strings.ToLower(strings.Replace(state, " ", "_", -1))
+}
+
+func info(c *collector.Collection) (HadoopNameNodeJmxInfo, bool) {
 	ret := HadoopNameNodeJmxInfo {
+		RpcSchedulers: map[string]*RpcScheduler{},
 	}
 	// http://localhost:50070/jmx
-	req := gorequest.New()
-	_, body, errs := req.Retry(1, 5 * time.Second,
-		http.StatusBadRequest, http.StatusInternalServerError).Get(*nameNodeJmxUrl).End()
-	if errs != nil {
+	body, ok := collector.Fetch(c.Target)
+	if !ok {
 		return ret, false
 	}
 
 	var f interface{}
 	err := json.Unmarshal([]byte(body), &f)
 	if err != nil {
+		collector.LogAt(collector.LevelError, "cannot decode payload", "target", c.Target, "err", err)
+		return ret, false
+	}
+	m, _ := f.(map[string]interface{})
+	nameList, ok := m["beans"].([]interface{})
+	if !ok {
+		collector.LogAt(collector.LevelError, "payload has no beans", "target", c.Target)
 		return ret, false
 	}
-	m := f.(map[string]interface{})
-	var nameList = m["beans"].([]interface{})
+	c.Up["jmx"] = true
 	for _, nameData := range nameList {
-		nameDataMap := nameData.(map[string]interface{})
+		nameDataMap, _ := nameData.(map[string]interface{})
+		ret.Quantiles = append(ret.Quantiles, collector.Quantiles(nameDataMap)...)
 
 		if nameDataMap["name"] == "java.lang:type=Memory" {
-			heapMemoryUsage := nameDataMap["HeapMemoryUsage"].(map[string]interface{})
-			ret.MemoryInfo.heapMemoryUsageCommitted = heapMemoryUsage["committed"].(float64)
-			ret.MemoryInfo.heapMemoryUsageInit = heapMemoryUsage["init"].(float64)
-			ret.MemoryInfo.heapMemoryUsageMax = heapMemoryUsage["max"].(float64)
-			ret.MemoryInfo.heapMemoryUsageUsed = heapMemoryUsage["used"].(float64)
+			get := c.Bean("Memory", nameDataMap["HeapMemoryUsage"])
+			ret.MemoryInfo.heapMemoryUsageCommitted = get("committed")
+			ret.MemoryInfo.heapMemoryUsageInit = get("init")
+			ret.MemoryInfo.heapMemoryUsageMax = get("max")
+			ret.MemoryInfo.heapMemoryUsageUsed = get("used")
 		}
 
 		if nameDataMap["name"] == "Hadoop:service=NameNode,name=FSNamesystem" {
-			ret.FSNamesystemInfo.MissingBlocks = nameDataMap["MissingBlocks"].(float64)
-			ret.FSNamesystemInfo.CapacityTotalGB = nameDataMap["CapacityTotalGB"].(float64)
-			ret.FSNamesystemInfo.CapacityUsedGB = nameDataMap["CapacityUsedGB"].(float64)
-			ret.FSNamesystemInfo.CapacityRemainingGB = nameDataMap["CapacityRemainingGB"].(float64)
-			ret.FSNamesystemInfo.BlocksTotal = nameDataMap["BlocksTotal"].(float64)
-			ret.FSNamesystemInfo.FilesTotal = nameDataMap["FilesTotal"].(float64)
-			ret.FSNamesystemInfo.CorruptBlocks = nameDataMap["CorruptBlocks"].(float64)
-			ret.FSNamesystemInfo.ExcessBlocks = nameDataMap["ExcessBlocks"].(float64)
-			ret.FSNamesystemInfo.TotalLoad = nameDataMap["TotalLoad"].(float64)
-			ret.FSNamesystemInfo.ScheduledReplicationBlocks = nameDataMap["ScheduledReplicationBlocks"].(float64)
-			ret.FSNamesystemInfo.PendingReplicationBlocks = nameDataMap["PendingReplicationBlocks"].(float64)
+			get := c.Bean("FSNamesystem", nameDataMap)
+			ret.FSNamesystemInfo.MissingBlocks = get("MissingBlocks")
+			ret.FSNamesystemInfo.CapacityTotalGB = get("CapacityTotalGB")
+			ret.FSNamesystemInfo.CapacityUsedGB = get("CapacityUsedGB")
+			ret.FSNamesystemInfo.CapacityRemainingGB = get("CapacityRemainingGB")
+			ret.FSNamesystemInfo.BlocksTotal = get("BlocksTotal")
+			ret.FSNamesystemInfo.FilesTotal = get("FilesTotal")
+			ret.FSNamesystemInfo.CorruptBlocks = get("CorruptBlocks")
+			ret.FSNamesystemInfo.ExcessBlocks = get("ExcessBlocks")
+			ret.FSNamesystemInfo.TotalLoad = get("TotalLoad")
+			ret.FSNamesystemInfo.ScheduledReplicationBlocks = get("ScheduledReplicationBlocks")
+			ret.FSNamesystemInfo.PendingReplicationBlocks = get("PendingReplicationBlocks")
+			ret.FSNamesystemInfo.HAState, _ = nameDataMap["tag.HAState"].(string)
+			ret.FSNamesystemInfo.Optional = collector.Optional(nameDataMap,
+				"NumDecommissioningDataNodes", "NumDecomLiveDataNodes", "NumDecomDeadDataNodes",
+				"NumEnteringMaintenanceDataNodes", "NumInMaintenanceLiveDataNodes",
+				"NumInMaintenanceDeadDataNodes", "StaleDataNodes",
+				"PendingDeletionBlocks", "LowRedundancyBlocks", "MissingReplOneBlocks",
+				"LastCheckpointTime", "LastWrittenTransactionId", "MillisSinceLastLoadedEdits",
+				"TransactionsSinceLastCheckpoint", "SnapshottableDirectories", "Snapshots",
+				"NumEncryptionZones", "LockQueueLength", "TotalSyncCount")
+			ret.FSNamesystemInfo.LockHoldTimes = lockHoldTimes(nameDataMap)
+			if syncTimes, ok := nameDataMap["tag.TotalSyncTimes"].(string); ok {
+				for _, field := range strings.Fields(syncTimes) {
+					if v, err := strconv.ParseFloat(field, 64); err == nil {
+						ret.FSNamesystemInfo.TotalSyncTimes = append(ret.FSNamesystemInfo.TotalSyncTimes, v)
+					}
+				}
+			}
+		}
+
+		if nameDataMap["name"] == "Hadoop:service=NameNode,name=NameNodeStatus" {
+			get := c.Bean("NameNodeStatus", nameDataMap)
+			ret.NameNodeStatusInfo.State, _ = nameDataMap["State"].(string)
+			ret.NameNodeStatusInfo.LastHATransitionTime = get("LastHATransitionTime")
+		}
+
+		if nameDataMap["name"] == "Hadoop:service=NameNode,name=StartupProgress" {
+			get := c.Bean("StartupProgress", nameDataMap)
+			ret.StartupProgressInfo.ElapsedTime = get("ElapsedTime")
+			ret.StartupProgressInfo.PercentComplete = get("PercentComplete")
+			for _, phase := range startupPhases {
+				ret.StartupProgressInfo.Phases = append(ret.StartupProgressInfo.Phases, StartupPhase{
+					Name:            phase,
+					Count:           get(phase + "Count"),
+					Total:           get(phase + "Total"),
+					PercentComplete: get(phase + "PercentComplete"),
+					ElapsedTime:     get(phase + "ElapsedTime"),
+				})
+			}
+		}
+
+		beanName, _ := nameDataMap["name"].(string)
+		if strings.HasPrefix(beanName, "Hadoop:service=NameNode,name=RpcActivityForPort") {
+			ret.RpcActivityInfo = append(ret.RpcActivityInfo, RpcActivity{
+				Port:   strings.TrimPrefix(beanName, "Hadoop:service=NameNode,name=RpcActivityForPort"),
+				Values: collector.Optional(nameDataMap, rpcActivityAttrs...),
+			})
+		}
+
+		if strings.HasPrefix(beanName, "Hadoop:service=NameNode,name=RpcDetailedActivityForPort") {
+			ret.RpcDetailedActivityInfo = append(ret.RpcDetailedActivityInfo, rpcDetailedActivity(
+				strings.TrimPrefix(beanName, "Hadoop:service=NameNode,name=RpcDetailedActivityForPort"),
+				nameDataMap))
+		}
+
+		if strings.Contains(beanName, "name=DecayRpcSchedulerMetrics2.") {
+			decayRpcScheduler(rpcScheduler(ret.RpcSchedulers, beanName), nameDataMap)
+		}
+
+		if strings.HasSuffix(beanName, ",name=FairCallQueue") {
+			r := rpcScheduler(ret.RpcSchedulers, beanName)
+			r.QueueSizes = floats(nameDataMap["QueueSizes"])
+			r.OverflowedCalls = floats(nameDataMap["OverflowedCalls"])
+		}
+
+		for _, b := range blocksStateAttrs {
+			if nameDataMap["name"] != "Hadoop:service=NameNode,name="+b.bean {
+				continue
+			}
+			c.Bean(b.bean, nameDataMap)
+			state := BlocksState{Kind: b.kind, Counts: map[string]float64{}}
+			for _, attr := range b.attrs {
+				if v, ok := collector.Optional(nameDataMap, attr[0])[attr[0]]; ok {
+					state.Counts[attr[1]] = v
+				}
+			}
+			ret.BlocksStates = append(ret.BlocksStates, state)
+		}
+
+		if nameDataMap["name"] == "java.lang:type=Runtime" {
+			ret.RuntimeInfo = collector.RuntimeInfo(c, nameDataMap)
+		}
+
+		if nameDataMap["name"] == "Hadoop:service=NameNode,name=SnapshotInfo" {
+			c.Bean("SnapshotInfo", nameDataMap)
+			ret.SnapshottableDirectories = snapshottableDirectories(c, nameDataMap["SnapshottableDirectories"])
+		}
+
+		if nameDataMap["name"] == "Hadoop:service=NameNode,name=BlockStats" {
+			c.Bean("BlockStats", nameDataMap)
+			ret.StorageTypeStats = storageTypeStats(c, nameDataMap["StorageTypeStats"])
+		}
+
+		if nameDataMap["name"] == "Hadoop:service=NameNode,name=NameNodeInfo" {
+			c.Bean("NameNodeInfo", nameDataMap)
+			ret.NameNodeInfo.LiveNodes = dataNodes(c, nameDataMap, "LiveNodes")
+			ret.NameNodeInfo.DeadNodes = dataNodes(c, nameDataMap, "DeadNodes")
+			ret.NameNodeInfo.DecomNodes = dataNodes(c, nameDataMap, "DecomNodes")
+			version, _ := nameDataMap["Version"].(string)
+			ret.NameNodeInfo.Version, ret.NameNodeInfo.Revision = collector.SplitVersion(version)
+			if compileInfo, ok := nameDataMap["CompileInfo"].(string); ok {
+				// <date> by <user> from <branch>
+				ret.NameNodeInfo.CompileDate = strings.SplitN(compileInfo, " ", 2)[0]
+			}
+			ret.NameNodeInfo.ClusterId, _ = nameDataMap["ClusterId"].(string)
+			ret.NameNodeInfo.BlockPoolId, _ = nameDataMap["BlockPoolId"].(string)
+			if tip, ok := nameDataMap["Safemode"].(string); ok {
+				ret.NameNodeInfo.SafeMode = safeMode(tip)
+			} else {
+				c.Fail("NameNodeInfo", "Safemode", nameDataMap["Safemode"])
+			}
+			if raw, ok := nameDataMap["SlowPeersReport"].(string); ok && raw != "" {
+				ret.NameNodeInfo.SlowPeers, ret.NameNodeInfo.HasSlowPeersReport = slowPeers(c, raw)
+			}
+			if raw, ok := nameDataMap["SlowDisksReport"].(string); ok && raw != "" {
+				ret.NameNodeInfo.SlowDisks, ret.NameNodeInfo.HasSlowDisksReport = slowDisks(c, raw)
+			}
+			if raw, ok := nameDataMap["JournalTransactionInfo"].(string); ok {
+				ret.NameNodeInfo.JournalTransactionInfo = journalTransactionInfo(c, raw)
+			}
+			if _, ok := nameDataMap["EnteringMaintenanceNodes"]; ok {
+				ret.NameNodeInfo.EnteringMaintenanceNodes = dataNodes(c, nameDataMap, "EnteringMaintenanceNodes")
+			}
 		}
 
 		if nameDataMap["name"] == "Hadoop:service=NameNode,name=FSNamesystemState" {
-			ret.FSNamesystemStateInfo.CapacityTotal = nameDataMap["CapacityTotal"].(float64)
-			ret.FSNamesystemStateInfo.CapacityUsed = nameDataMap["CapacityUsed"].(float64)
-			ret.FSNamesystemStateInfo.CapacityRemaining = nameDataMap["CapacityRemaining"].(float64)
-			ret.FSNamesystemStateInfo.TotalLoad = nameDataMap["TotalLoad"].(float64)
-			ret.FSNamesystemStateInfo.BlocksTotal = nameDataMap["BlocksTotal"].(float64)
-			ret.FSNamesystemStateInfo.FilesTotal = nameDataMap["FilesTotal"].(float64)
-			ret.FSNamesystemStateInfo.PendingReplicationBlocks = nameDataMap["PendingReplicationBlocks"].(float64)
-			ret.FSNamesystemStateInfo.UnderReplicatedBlocks = nameDataMap["UnderReplicatedBlocks"].(float64)
-			ret.FSNamesystemStateInfo.ScheduledReplicationBlocks = nameDataMap["ScheduledReplicationBlocks"].(float64)
-			ret.FSNamesystemStateInfo.NumLiveDataNodes = nameDataMap["NumLiveDataNodes"].(float64)
-			ret.FSNamesystemStateInfo.NumDeadDataNodes = nameDataMap["NumDeadDataNodes"].(float64)
+			get := c.Bean("FSNamesystemState", nameDataMap)
+			ret.FSNamesystemStateInfo.CapacityTotal = get("CapacityTotal")
+			ret.FSNamesystemStateInfo.CapacityUsed = get("CapacityUsed")
+			ret.FSNamesystemStateInfo.CapacityRemaining = get("CapacityRemaining")
+			ret.FSNamesystemStateInfo.TotalLoad = get("TotalLoad")
+			ret.FSNamesystemStateInfo.BlocksTotal = get("BlocksTotal")
+			ret.FSNamesystemStateInfo.FilesTotal = get("FilesTotal")
+			ret.FSNamesystemStateInfo.PendingReplicationBlocks = get("PendingReplicationBlocks")
+			ret.FSNamesystemStateInfo.UnderReplicatedBlocks = get("UnderReplicatedBlocks")
+			ret.FSNamesystemStateInfo.ScheduledReplicationBlocks = get("ScheduledReplicationBlocks")
+			ret.FSNamesystemStateInfo.NumLiveDataNodes = get("NumLiveDataNodes")
+			ret.FSNamesystemStateInfo.NumDeadDataNodes = get("NumDeadDataNodes")
+			ret.FSNamesystemStateInfo.Optional = collector.Optional(nameDataMap, "NumStaleDataNodes")
+			for _, attr := range []string{"TopUserOpCounts", "NNTopUserOpCounts"} {
+				raw, ok := nameDataMap[attr].(string)
+				if !ok {
+					continue
+				}
+				var top struct {
+					Windows []TopWindow `json:"windows"`
+				}
+				if err := json.Unmarshal([]byte(raw), &top); err != nil {
+					c.Fail("FSNamesystemState", attr, raw)
+				}
+				ret.FSNamesystemStateInfo.TopUserOpCounts = top.Windows
+			}
 		}
 
 		if nameDataMap["name"] == "Hadoop:service=NameNode,name=NameNodeActivity" {
-			ret.NameNodeActivityInfo.CreateFileOps = nameDataMap["CreateFileOps"].(float64)
-			ret.NameNodeActivityInfo.FilesCreated = nameDataMap["FilesCreated"].(float64)
-			ret.NameNodeActivityInfo.FilesAppended = nameDataMap["FilesAppended"].(float64)
-			ret.NameNodeActivityInfo.GetBlockLocations = nameDataMap["GetBlockLocations"].(float64)
-			ret.NameNodeActivityInfo.FilesRenamed = nameDataMap["FilesRenamed"].(float64)
-			ret.NameNodeActivityInfo.GetListingOps = nameDataMap["GetListingOps"].(float64)
-			ret.NameNodeActivityInfo.DeleteFileOps = nameDataMap["DeleteFileOps"].(float64)
-			ret.NameNodeActivityInfo.FilesDeleted = nameDataMap["FilesDeleted"].(float64)
-			ret.NameNodeActivityInfo.FileInfoOps = nameDataMap["FileInfoOps"].(float64)
-
-			ret.NameNodeActivityInfo.AddBlockOps = nameDataMap["AddBlockOps"].(float64)
-			ret.NameNodeActivityInfo.GetAdditionalDatanodeOps = nameDataMap["GetAdditionalDatanodeOps"].(float64)
-			ret.NameNodeActivityInfo.CreateSymlinkOps = nameDataMap["CreateSymlinkOps"].(float64)
-			ret.NameNodeActivityInfo.GetLinkTargetOps = nameDataMap["GetLinkTargetOps"].(float64)
-			ret.NameNodeActivityInfo.FilesInGetListingOps = nameDataMap["FilesInGetListingOps"].(float64)
-			ret.NameNodeActivityInfo.StorageBlockReportOps = nameDataMap["StorageBlockReportOps"].(float64)
-			ret.NameNodeActivityInfo.TransactionsNumOps = nameDataMap["TransactionsNumOps"].(float64)
-			ret.NameNodeActivityInfo.TransactionsAvgTime = nameDataMap["TransactionsAvgTime"].(float64)
-
-			ret.NameNodeActivityInfo.SyncsNumOps = nameDataMap["SyncsNumOps"].(float64)
-			ret.NameNodeActivityInfo.SyncsAvgTime = nameDataMap["SyncsAvgTime"].(float64)
-			ret.NameNodeActivityInfo.TransactionsBatchedInSync = nameDataMap["TransactionsBatchedInSync"].(float64)
-			ret.NameNodeActivityInfo.BlockReportNumOps = nameDataMap["BlockReportNumOps"].(float64)
-			ret.NameNodeActivityInfo.BlockReportAvgTime = nameDataMap["BlockReportAvgTime"].(float64)
-			ret.NameNodeActivityInfo.SafeModeTime = nameDataMap["SafeModeTime"].(float64)
-			ret.NameNodeActivityInfo.FsImageLoadTime = nameDataMap["FsImageLoadTime"].(float64)
-
-			ret.NameNodeActivityInfo.GetEditNumOps = nameDataMap["GetEditNumOps"].(float64)
-			ret.NameNodeActivityInfo.GetEditAvgTime = nameDataMap["GetEditAvgTime"].(float64)
-			ret.NameNodeActivityInfo.GetImageNumOps = nameDataMap["GetImageNumOps"].(float64)
-			ret.NameNodeActivityInfo.GetImageAvgTime = nameDataMap["GetImageAvgTime"].(float64)
-			ret.NameNodeActivityInfo.PutImageNumOps = nameDataMap["PutImageNumOps"].(float64)
-			ret.NameNodeActivityInfo.PutImageAvgTime = nameDataMap["PutImageAvgTime"].(float64)
+			get := c.Bean("NameNodeActivity", nameDataMap)
+			ret.NameNodeActivityInfo.CreateFileOps = get("CreateFileOps")
+			ret.NameNodeActivityInfo.FilesCreated = get("FilesCreated")
+			ret.NameNodeActivityInfo.FilesAppended = get("FilesAppended")
+			ret.NameNodeActivityInfo.GetBlockLocations = get("GetBlockLocations")
+			ret.NameNodeActivityInfo.FilesRenamed = get("FilesRenamed")
+			ret.NameNodeActivityInfo.GetListingOps = get("GetListingOps")
+			ret.NameNodeActivityInfo.DeleteFileOps = get("DeleteFileOps")
+			ret.NameNodeActivityInfo.FilesDeleted = get("FilesDeleted")
+			ret.NameNodeActivityInfo.FileInfoOps = get("FileInfoOps")
+
+			ret.NameNodeActivityInfo.AddBlockOps = get("AddBlockOps")
+			ret.NameNodeActivityInfo.GetAdditionalDatanodeOps = get("GetAdditionalDatanodeOps")
+			ret.NameNodeActivityInfo.CreateSymlinkOps = get("CreateSymlinkOps")
+			ret.NameNodeActivityInfo.GetLinkTargetOps = get("GetLinkTargetOps")
+			ret.NameNodeActivityInfo.FilesInGetListingOps = get("FilesInGetListingOps")
+			ret.NameNodeActivityInfo.StorageBlockReportOps = get("StorageBlockReportOps")
+			ret.NameNodeActivityInfo.TransactionsNumOps = get("TransactionsNumOps")
+			ret.NameNodeActivityInfo.TransactionsAvgTime = get("TransactionsAvgTime")
+
+			ret.NameNodeActivityInfo.SyncsNumOps = get("SyncsNumOps")
+			ret.NameNodeActivityInfo.SyncsAvgTime = get("SyncsAvgTime")
+			ret.NameNodeActivityInfo.TransactionsBatchedInSync = get("TransactionsBatchedInSync")
+			ret.NameNodeActivityInfo.BlockReportNumOps = get("BlockReportNumOps")
+			ret.NameNodeActivityInfo.BlockReportAvgTime = get("BlockReportAvgTime")
+			ret.NameNodeActivityInfo.SafeModeTime = get("SafeModeTime")
+			ret.NameNodeActivityInfo.FsImageLoadTime = get("FsImageLoadTime")
+
+			ret.NameNodeActivityInfo.GetEditNumOps = get("GetEditNumOps")
+			ret.NameNodeActivityInfo.GetEditAvgTime = get("GetEditAvgTime")
+			ret.NameNodeActivityInfo.GetImageNumOps = get("GetImageNumOps")
+			ret.NameNodeActivityInfo.GetImageAvgTime = get("GetImageAvgTime")
+			ret.NameNodeActivityInfo.PutImageNumOps = get("PutImageNumOps")
+			ret.NameNodeActivityInfo.PutImageAvgTime = get("PutImageAvgTime")
+			var attrs []string
+			for _, op := range nameNodeActivityOps {
+				attrs = append(attrs, op+"NumOps", op+"AvgTime")
+			}
+			ret.NameNodeActivityInfo.Optional = collector.Optional(nameDataMap, attrs...)
 		}
 
 		if nameDataMap["name"] == "Hadoop:service=NameNode,name=JvmMetrics" {
-			ret.JvmMetricsInfo.GcTimeMillis = nameDataMap["GcTimeMillis"].(float64)
-			ret.JvmMetricsInfo.GcTimeMillisParNew = nameDataMap["GcTimeMillisParNew"].(float64)
-			ret.JvmMetricsInfo.GcTimeMillisConcurrentMarkSweep = nameDataMap["GcTimeMillisConcurrentMarkSweep"].(float64)
-			ret.JvmMetricsInfo.GcCount = nameDataMap["GcCount"].(float64)
-			ret.JvmMetricsInfo.GcCountParNew = nameDataMap["GcCountParNew"].(float64)
-			ret.JvmMetricsInfo.GcCountConcurrentMarkSweep = nameDataMap["GcCountConcurrentMarkSweep"].(float64)
-			ret.JvmMetricsInfo.ThreadsBlocked = nameDataMap["ThreadsBlocked"].(float64)
-			ret.JvmMetricsInfo.ThreadsWaiting = nameDataMap["ThreadsWaiting"].(float64)
+			get := c.Bean("JvmMetrics", nameDataMap)
+			ret.JvmMetricsInfo.GcTimeMillis = get("GcTimeMillis")
+			ret.JvmMetricsInfo.GcCount = get("GcCount")
+			ret.JvmMetricsInfo.GcCollectors = collector.Optional(nameDataMap,
+				"GcTimeMillisParNew", "GcTimeMillisConcurrentMarkSweep",
+				"GcCountParNew", "GcCountConcurrentMarkSweep")
+			ret.JvmMetricsInfo.ThreadsBlocked = get("ThreadsBlocked")
+			ret.JvmMetricsInfo.ThreadsWaiting = get("ThreadsWaiting")
 		}
 	}
 
 	return ret, true
 }
 
+// haState is the HA state the NameNode reports, empty if it reports none.
+func (s HadoopNameNodeJmxInfo) haState() string {
+	if s.NameNodeStatusInfo.State != "" {
+		return s.NameNodeStatusInfo.State
+	}
+	return s.FSNamesystemInfo.HAState
+}
+
+// nameNode is one NameNode of the nameservice; id is its nn_id.
+type nameNode struct {
+	id  string
+	url string
+}
+
+// nameNodes returns the NameNodes to collect: every entry of
+// -nameservice.urls, or else the single -jmx.url NameNode without an id.
+func nameNodes() []nameNode {
+	if *nameServiceUrls == "" {
+		return []nameNode{{url: *nameNodeJmxUrl}}
+	}
+	var ret []nameNode
+	for _, entry := range strings.Split(*nameServiceUrls, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		nn := nameNode{id: entry, url: entry}
+		if i := strings.Index(entry, "="); i >= 0 {
+			nn.id, nn.url = entry[:i], entry[i+1:]
+		} else if u, err := url.Parse(entry); err == nil {
+			nn.id = u.Host
+		}
+		ret = append(ret, nn)
+	}
+	return ret
+}
+
+// withLabel adds name="value" to every metric line of text.
+func withLabel(text, name, value string) string {
+	if value == "" {
+		return text
+	}
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Replace(line, "{", "{"+name+"=\""+value+"\",", 1)
+	}
+	return strings.Join(lines, "")
+}
+
+// DirectoryUsage is what WebHDFS reports about one directory of
+// -quota.paths. Quotas are -1 where none is set.
+type DirectoryUsage struct {
+	Path           string
+	FileCount      float64
+	DirectoryCount float64
+	Length         float64
+	NamespaceUsed  float64
+	NamespaceQuota float64
+	SpaceConsumed  float64
+	SpaceQuota     float64
+	TypeUsage      []StorageTypeUsage
+
+	// Only the content summary counts files and directories.
+	HasContentSummary bool
+
+	// Response bodies that could not be decoded, by operation.
+	undecodable map[string]string
+	// HTTP status of GETQUOTAUSAGE, 0 where it was not answered.
+	quotaUsageStatus int
+}
+
+// StorageTypeUsage is the space consumed and quota of one storage type.
+type StorageTypeUsage struct {
+	StorageType string
+	Consumed    float64
+	Quota       float64
+}
+
+// quotaUsage is the QuotaUsage object of WebHDFS, which ContentSummary
+// extends.
+type quotaUsage struct {
+	FileAndDirectoryCount *float64 `json:"fileAndDirectoryCount"`
+	Quota                 float64  `json:"quota"`
+	SpaceConsumed         float64  `json:"spaceConsumed"`
+	SpaceQuota            float64  `json:"spaceQuota"`
+	TypeQuota             map[string]struct {
+		Consumed float64 `json:"consumed"`
+		Quota    float64 `json:"quota"`
+	} `json:"typeQuota"`
+	DirectoryCount float64 `json:"directoryCount"`
+	FileCount      float64 `json:"fileCount"`
+	Length         float64 `json:"length"`
+}
+
+// webhdfsBase turns the JMX URL of a NameNode into the WebHDFS URL of the
+// file system root.
+func webhdfsBase(jmxUrl string) string {
+	u, err := url.Parse(jmxUrl)
+	if err != nil {
+		return ""
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/jmx") + "/webhdfs/v1"
+	u.RawQuery = ""
+	return u.String()
+}
+
+// webhdfs runs op on dir. Requests of every directory share the circuit
+// breaker and /debug/targets record of base; one rejected by WebHDFS, e.g.
+// for a directory removed meanwhile, leaves them alone and is returned with
+// its status.
+func webhdfs(base, dir, op string) (string, int, bool) {
+	query := url.Values{"op": {op}}
+	if *webhdfsUser != "" {
+		query.Set("user.name", *webhdfsUser)
+	}
+	body, status, ok := collector.FetchFrom(base, base+(&url.URL{Path: dir}).EscapedPath()+"?"+query.Encode())
+	// NameNodes before Hadoop 3 reject GETQUOTAUSAGE as unknown, which is
+	// expected and dealt with by directoryUsage.
+	unknownOp := op == "GETQUOTAUSAGE" && status == http.StatusBadRequest
+	if !ok && !unknownOp && status >= http.StatusBadRequest && status < http.StatusInternalServerError &&
+		status != http.StatusTooManyRequests {
+		collector.LogAt(collector.LevelWarn, "WebHDFS request rejected", "target", base,
+			"path", dir, "op", op, "status", status)
+	}
+	return body, status, ok
+}
+
+// expandGlob lists the directories matching pattern, listing the parent of
+// every path component holding a wildcard.
+func expandGlob(base, pattern string) ([]string, bool) {
+	dirs := []string{"/"}
+	for _, part := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		var next []string
+		for _, dir := range dirs {
+			if !strings.ContainsAny(part, "*?[") {
+				next = append(next, path.Join(dir, part))
+				continue
+			}
+			body, _, ok := webhdfs(base, dir, "LISTSTATUS")
+			if !ok {
+				return nil, false
+			}
+			var f struct {
+				FileStatuses struct {
+					FileStatus []struct {
+						PathSuffix string `json:"pathSuffix"`
+						Type       string `json:"type"`
+					}
+				}
+			}
+			if err := json.Unmarshal([]byte(body), &f); err != nil {
+				collector.LogAt(collector.LevelError, "cannot decode payload", "target", base, "path", dir, "err", err)
+				return nil, false
+			}
+			for _, status := range f.FileStatuses.FileStatus {
+				if matched, _ := path.Match(part, status.PathSuffix); matched && status.Type == "DIRECTORY" {
+					next = append(next, path.Join(dir, status.PathSuffix))
+				}
+			}
+		}
+		dirs = next
+	}
+	sort.Strings(dirs)
+	return dirs, true
+}
+
+// directoryUsage asks WebHDFS for the content summary and, if withQuotaUsage,
+// the quota usage of dir. Quota usage is cheaper to compute for the NameNode
+// but only there as of Hadoop 3, the content summary also counts files and
+// directories. Without quota usage the content summary is used alone.
+func directoryUsage(base, dir string, withQuotaUsage bool) (DirectoryUsage, bool) {
+	ret := DirectoryUsage{Path: dir, undecodable: map[string]string{}}
+	decode := func(op, key string) *quotaUsage {
+		body, status, ok := webhdfs(base, dir, op)
+		if op == "GETQUOTAUSAGE" {
+			ret.quotaUsageStatus = status
+		}
+		if !ok {
+			return nil
+		}
+		var f map[string]*quotaUsage
+		if err := json.Unmarshal([]byte(body), &f); err != nil || f[key] == nil {
+			ret.undecodable[op] = body
+			return nil
+		}
+		return f[key]
+	}
+
+	q := decode("GETCONTENTSUMMARY", "ContentSummary")
+	if q != nil {
+		ret.HasContentSummary = true
+		ret.FileCount = q.FileCount
+		ret.DirectoryCount = q.DirectoryCount
+		ret.Length = q.Length
+		ret.NamespaceUsed = q.FileCount + q.DirectoryCount
+	}
+	var usage *quotaUsage
+	if withQuotaUsage {
+		usage = decode("GETQUOTAUSAGE", "QuotaUsage")
+	}
+	if usage != nil {
+		if len(usage.TypeQuota) == 0 && q != nil {
+			usage.TypeQuota = q.TypeQuota
+		}
+		q = usage
+		if q.FileAndDirectoryCount != nil {
+			ret.NamespaceUsed = *q.FileAndDirectoryCount
+		}
+	}
+	if q == nil {
+		return ret, false
+	}
+
+	ret.NamespaceQuota = q.Quota
+	ret.SpaceConsumed = q.SpaceConsumed
+	ret.SpaceQuota = q.SpaceQuota
+	for storageType, t := range q.TypeQuota {
+		ret.TypeUsage = append(ret.TypeUsage, StorageTypeUsage{StorageType: storageType,
+			Consumed: t.Consumed, Quota: t.Quota})
+	}
+	sort.Slice(ret.TypeUsage, func(i, j int) bool {
+		return ret.TypeUsage[i].StorageType < ret.TypeUsage[j].StorageType
+	})
+	return ret, true
+}
+
+// directoryMetrics renders the quota and usage of the directories matching
+// -quota.paths, as reported by the NameNode serving jmxUrl.
+func directoryMetrics(jmxUrl string) string {
+	base := webhdfsBase(jmxUrl)
+	c := collector.NewCollection(base, "webhdfs")
+	c.Up["webhdfs"] = true
+
+	var dirs []string
+	seen := map[string]bool{}
+	for _, pattern := range strings.Split(*quotaPaths, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches, ok := expandGlob(base, pattern)
+		if !ok {
+			c.Up["webhdfs"] = false
+		}
+		for _, dir := range matches {
+			if !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	// Until the NameNode answered GETQUOTAUSAGE once, every directory tries
+	// it; a NameNode rejecting it is not asked again.
+	withQuotaUsage, known := g_quotaUsage[base]
+	withQuotaUsage = withQuotaUsage || !known
+	usages := make([]DirectoryUsage, len(dirs))
+	fetched := make([]bool, len(dirs))
+	var fns []func()
+	for i, dir := range dirs {
+		i, dir := i, dir
+		fns = append(fns, func() { usages[i], fetched[i] = directoryUsage(base, dir, withQuotaUsage) })
+	}
+	collector.Parallel(*concurrency, fns...)
+
+	ret := ""
+	nameSpace := "hadoop_"
+	statuses := map[int]bool{}
+	for _, u := range usages {
+		statuses[u.quotaUsageStatus] = true
+	}
+	if statuses[http.StatusOK] {
+		g_quotaUsage[base] = true
+	} else if statuses[http.StatusBadRequest] && !known {
+		g_quotaUsage[base] = false
+		collector.LogAt(collector.LevelInfo, "GETQUOTAUSAGE unsupported, using GETCONTENTSUMMARY alone",
+			"target", base)
+	}
+
+	for i, u := range usages {
+		for op, body := range u.undecodable {
+			c.Fail("webhdfs", op+" "+u.Path, body)
+		}
+		if !fetched[i] {
+			c.Up["webhdfs"] = false
+			continue
+		}
+
+		labels := fmt.Sprintf("path=\"%s\",role=\"%s\"", collector.EscapeLabel(u.Path), *role)
+		if u.HasContentSummary {
+			ret += fmt.Sprintf("%s_directory_files{%s} %g\n", nameSpace, labels, u.FileCount)
+			ret += fmt.Sprintf("%s_directory_directories{%s} %g\n", nameSpace, labels, u.DirectoryCount)
+			ret += fmt.Sprintf("%s_directory_length_bytes{%s} %g\n", nameSpace, labels, u.Length)
+		}
+		ret += fmt.Sprintf("%s_directory_namespace_used{%s} %g\n", nameSpace, labels, u.NamespaceUsed)
+		ret += fmt.Sprintf("%s_directory_space_consumed_bytes{%s} %g\n", nameSpace, labels, u.SpaceConsumed)
+		if u.NamespaceQuota >= 0 {
+			ret += fmt.Sprintf("%s_directory_namespace_quota{%s} %g\n", nameSpace, labels, u.NamespaceQuota)
+		}
+		if u.SpaceQuota >= 0 {
+			ret += fmt.Sprintf("%s_directory_space_quota_bytes{%s} %g\n", nameSpace, labels, u.SpaceQuota)
+		}
+		for _, t := range u.TypeUsage {
+			typeLabels := fmt.Sprintf("%s,storage_type=\"%s\"", labels, t.StorageType)
+			ret += fmt.Sprintf("%s_directory_type_space_consumed_bytes{%s} %g\n", nameSpace, typeLabels, t.Consumed)
+			if t.Quota >= 0 {
+				ret += fmt.Sprintf("%s_directory_type_space_quota_bytes{%s} %g\n", nameSpace, typeLabels, t.Quota)
+			}
+		}
+	}
+	return ret + collector.SourceMetrics(c)
+}
+
+// instanceMetrics renders what describes one NameNode process itself and is
+// therefore collected from every NameNode of the nameservice.
+func instanceMetrics(s HadoopNameNodeJmxInfo, c *collector.Collection) string {
+	ret := ""
+	nameSpace := "hadoop_"
+
+	if c.Up["Memory"] {
+		// Memory
+		ret += fmt.Sprintf("%s_heap_memory{type=\"committed\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.MemoryInfo.heapMemoryUsageCommitted)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"init\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.MemoryInfo.heapMemoryUsageInit)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"max\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.MemoryInfo.heapMemoryUsageMax)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"used\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.MemoryInfo.heapMemoryUsageUsed)
+	}
+
+	if c.Up["NameNodeActivity"] {
+		// NameNodeActivity
+		ret += fmt.Sprintf("%s_activity_create_file_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.CreateFileOps)
+		ret += fmt.Sprintf("%s_activity_file_created{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.FilesCreated)
+		ret += fmt.Sprintf("%s_activity_create_file_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.CreateFileOps)
+		ret += fmt.Sprintf("%s_activity_get_block_locations{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.GetBlockLocations)
+		ret += fmt.Sprintf("%s_activity_files_renamed{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.FilesRenamed)
+		ret += fmt.Sprintf("%s_activity_get_listing_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.GetListingOps)
+		ret += fmt.Sprintf("%s_activity_get_delete_file_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.DeleteFileOps)
+		ret += fmt.Sprintf("%s_activity_get_files_deleted{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.FilesDeleted)
+		ret += fmt.Sprintf("%s_activity_file_info_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.FileInfoOps)
+
+		ret += fmt.Sprintf("%s_activity_block_add_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.AddBlockOps)
+		ret += fmt.Sprintf("%s_activity_get_additional_datanode_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.GetAdditionalDatanodeOps)
+		ret += fmt.Sprintf("%s_activity_create_symlink_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.CreateSymlinkOps)
+		ret += fmt.Sprintf("%s_activity_get_link_target_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.GetLinkTargetOps)
+		ret += fmt.Sprintf("%s_activity_files_in_get_listing_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.FilesInGetListingOps)
+		ret += fmt.Sprintf("%s_activity_storage_block_report_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.StorageBlockReportOps)
+		ret += fmt.Sprintf("%s_activity_transactions_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.TransactionsNumOps)
+		ret += fmt.Sprintf("%s_activity_transactions_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.TransactionsAvgTime)
+
+		ret += fmt.Sprintf("%s_activity_syncs_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.SyncsNumOps)
+		ret += fmt.Sprintf("%s_activity_syncs_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.SyncsAvgTime)
+		ret += fmt.Sprintf("%s_activity_transactions_batched_in_sync{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.TransactionsBatchedInSync)
+		ret += fmt.Sprintf("%s_activity_block_report_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.BlockReportNumOps)
+		ret += fmt.Sprintf("%s_activity_block_report_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.BlockReportAvgTime)
+		ret += fmt.Sprintf("%s_activity_safemode_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.SafeModeTime)
+		ret += fmt.Sprintf("%s_activity_fs_image_load_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.FsImageLoadTime)
+
+		ret += fmt.Sprintf("%s_activity_get_edit_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.GetEditNumOps)
+		ret += fmt.Sprintf("%s_activity_get_edit_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.GetEditAvgTime)
+		ret += fmt.Sprintf("%s_activity_get_image_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.GetImageNumOps)
+		ret += fmt.Sprintf("%s_activity_get_image_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.GetImageAvgTime)
+		ret += fmt.Sprintf("%s_activity_put_image_num_ops{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.PutImageNumOps)
+		ret += fmt.Sprintf("%s_activity_put_image_avg_time{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeActivityInfo.PutImageAvgTime)
+		for _, op := range nameNodeActivityOps {
+			if v, ok := s.NameNodeActivityInfo.Optional[op+"NumOps"]; ok {
+				ret += fmt.Sprintf("%s_activity_%s_num_ops{role=\"%s\"} %g\n",
+					nameSpace, collector.SnakeCase(op), *role, v)
+			}
+			if v, ok := s.NameNodeActivityInfo.Optional[op+"AvgTime"]; ok {
+				ret += fmt.Sprintf("%s_activity_%s_avg_time{role=\"%s\"} %g\n",
+					nameSpace, collector.SnakeCase(op), *role, v)
+			}
+		}
+	}
+
+	if c.Up["FSNamesystem"] {
+		// FSNamesystem lock and edit log sync, which differ per NameNode
+		for _, l := range s.FSNamesystemInfo.LockHoldTimes {
+			ret += fmt.Sprintf("%s_fs_name_system_lock_nanos_num_ops{lock=\"%s\",op=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, l.Lock, l.Op, *role, l.NumOps)
+			ret += fmt.Sprintf("%s_fs_name_system_lock_nanos_avg_time{lock=\"%s\",op=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, l.Lock, l.Op, *role, l.AvgTime)
+		}
+		if v, ok := s.FSNamesystemInfo.Optional["LockQueueLength"]; ok {
+			ret += fmt.Sprintf("%s_fs_name_system_lock_queue_length{role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		if v, ok := s.FSNamesystemInfo.Optional["TotalSyncCount"]; ok {
+			ret += fmt.Sprintf("%s_fs_name_system_total_sync_count{role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		for journal, v := range s.FSNamesystemInfo.TotalSyncTimes {
+			ret += fmt.Sprintf("%s_fs_name_system_journal_sync_time_millis{journal=\"%d\",role=\"%s\"} %g\n",
+				nameSpace, journal, *role, v)
+		}
+	}
+
+	if c.Up["NameNodeInfo"] {
+		// JournalTransactionInfo, which tells how far behind a standby is
+		for _, txid := range []struct{ key, metric string }{
+			{"LastAppliedOrWrittenTxId", "last_applied_or_written_txid"},
+			{"MostRecentCheckpointTxId", "most_recent_checkpoint_txid"},
+		} {
+			if v, ok := s.NameNodeInfo.JournalTransactionInfo[txid.key]; ok {
+				ret += fmt.Sprintf("%s_journal_%s{role=\"%s\"} %g\n",
+					nameSpace, txid.metric, *role, v)
+			}
+		}
+	}
+
+	if c.Up["JvmMetrics"] {
+		// JvmMetrics
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_time_total_millis{role=\"%s\"} %g\n",
+			nameSpace, *role, s.JvmMetricsInfo.GcTimeMillis)
+		if v, ok := s.JvmMetricsInfo.GcCollectors["GcTimeMillisParNew"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"par_new\",role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		if v, ok := s.JvmMetricsInfo.GcCollectors["GcTimeMillisConcurrentMarkSweep"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"concurrent_mark_sweep\",role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_count_total{role=\"%s\"} %g\n",
+			nameSpace, *role, s.JvmMetricsInfo.GcCount)
+		if v, ok := s.JvmMetricsInfo.GcCollectors["GcCountParNew"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_count{type=\"par_new\",role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		if v, ok := s.JvmMetricsInfo.GcCollectors["GcCountConcurrentMarkSweep"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_count{type=\"concurrent_mark_sweep\",role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_blocked{role=\"%s\"} %g\n",
+			nameSpace, *role, s.JvmMetricsInfo.ThreadsBlocked)
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_waiting{role=\"%s\"} %g\n",
+			nameSpace, *role, s.JvmMetricsInfo.ThreadsWaiting)
+	}
+
+	ret += collector.QuantileMetrics(s.Quantiles)
+
+	// RpcActivityForPort
+	sort.Slice(s.RpcActivityInfo, func(i, j int) bool {
+		return s.RpcActivityInfo[i].Port < s.RpcActivityInfo[j].Port
+	})
+	for _, rpc := range s.RpcActivityInfo {
+		for _, attr := range rpcActivityAttrs {
+			if v, ok := rpc.Values[attr]; ok {
+				ret += fmt.Sprintf("%s_rpc_%s{port=\"%s\",role=\"%s\"} %g\n",
+					nameSpace, collector.SnakeCase(strings.TrimPrefix(attr, "Rpc")), rpc.Port, *role, v)
+			}
+		}
+	}
+
+	// RpcDetailedActivityForPort
+	sort.Slice(s.RpcDetailedActivityInfo, func(i, j int) bool {
+		return s.RpcDetailedActivityInfo[i].Port < s.RpcDetailedActivityInfo[j].Port
+	})
+	for _, rpc := range s.RpcDetailedActivityInfo {
+		var methods []string
+		for method := range rpc.NumOps {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			ret += fmt.Sprintf("%s_rpc_method_num_ops{port=\"%s\",method=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, rpc.Port, method, *role, rpc.NumOps[method])
+			if v, ok := rpc.AvgTime[method]; ok {
+				ret += fmt.Sprintf("%s_rpc_method_avg_time{port=\"%s\",method=\"%s\",role=\"%s\"} %g\n",
+					nameSpace, rpc.Port, method, *role, v)
+			}
+		}
+	}
+
+	// FairCallQueue and DecayRpcScheduler
+	var ports []string
+	for port := range s.RpcSchedulers {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	for _, port := range ports {
+		r := s.RpcSchedulers[port]
+		for _, attr := range []string{"CallVolume", "DecayedCallVolume", "UniqueCallers"} {
+			if v, ok := r.Values[attr]; ok {
+				ret += fmt.Sprintf("%s_rpc_scheduler_%s{port=\"%s\",role=\"%s\"} %g\n",
+					nameSpace, collector.SnakeCase(attr), port, *role, v)
+			}
+		}
+
+		var callers []string
+		for caller := range r.CallerVolume {
+			callers = append(callers, caller)
+		}
+		sort.Slice(callers, func(i, j int) bool {
+			if r.CallerVolume[callers[i]] != r.CallerVolume[callers[j]] {
+				return r.CallerVolume[callers[i]] > r.CallerVolume[callers[j]]
+			}
+			return callers[i] < callers[j]
+		})
+		if len(callers) > *rpcTopCallers {
+			callers = callers[:*rpcTopCallers]
+		}
+		for _, caller := range callers {
+			ret += fmt.Sprintf("%s_rpc_scheduler_caller_volume{port=\"%s\",user=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, port, collector.EscapeLabel(caller), *role, r.CallerVolume[caller])
+			if v, ok := r.CallerPriority[caller]; ok {
+				ret += fmt.Sprintf("%s_rpc_scheduler_caller_priority{port=\"%s\",user=\"%s\",role=\"%s\"} %g\n",
+					nameSpace, port, collector.EscapeLabel(caller), *role, v)
+			}
+		}
+
+		var priorities []string
+		for priority := range r.PriorityResponseTime {
+			priorities = append(priorities, priority)
+		}
+		sort.Strings(priorities)
+		for _, priority := range priorities {
+			ret += fmt.Sprintf("%s_rpc_scheduler_priority_avg_response_time{port=\"%s\",priority=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, port, priority, *role, r.PriorityResponseTime[priority])
+			if v, ok := r.PriorityCallVolume[priority]; ok {
+				ret += fmt.Sprintf("%s_rpc_scheduler_priority_completed_call_volume{port=\"%s\",priority=\"%s\",role=\"%s\"} %g\n",
+					nameSpace, port, priority, *role, v)
+			}
+		}
+
+		for priority, v := range r.QueueSizes {
+			ret += fmt.Sprintf("%s_call_queue_length{port=\"%s\",priority=\"%d\",role=\"%s\"} %g\n",
+				nameSpace, port, priority, *role, v)
+		}
+		for priority, v := range r.OverflowedCalls {
+			ret += fmt.Sprintf("%s_call_queue_overflowed_calls{port=\"%s\",priority=\"%d\",role=\"%s\"} %g\n",
+				nameSpace, port, priority, *role, v)
+		}
+	}
+
+	// HA
+	if c.Up["Runtime"] {
+		// A restarted NameNode comes back with a new transition time, or none
+		// at all, so the state it reports first is a new baseline rather than
+		// a transition.
+		if start, ok := g_haStartTime[c.Target]; ok && start != s.RuntimeInfo.StartTime {
+			delete(g_haState, c.Target)
+			delete(g_haLastTransition, c.Target)
+		}
+		g_haStartTime[c.Target] = s.RuntimeInfo.StartTime
+	}
+	haState := s.haState()
+	if haState != "" {
+		// A changed state is one transition. An unchanged state with a newer
+		// transition time means the NameNode failed over and back in between;
+		// the time is only comparable when NameNodeStatus was read in both
+		// cycles, as older NameNodes report their state through FSNamesystem.
+		lastTransition := s.NameNodeStatusInfo.LastHATransitionTime
+		prevTransition, seen := g_haLastTransition[c.Target]
+		if g_haState[c.Target] != "" && haState != g_haState[c.Target] {
+			g_haTransitions[c.Target]++
+			collector.LogAt(collector.LevelWarn, "HA state changed", "target", c.Target, "from", g_haState[c.Target], "to", haState)
+		} else if g_haState[c.Target] != "" && c.Up["NameNodeStatus"] && seen && lastTransition != prevTransition {
+			g_haTransitions[c.Target] += 2
+			collector.LogAt(collector.LevelWarn, "HA state changed and back", "target", c.Target, "state", haState)
+		}
+		g_haState[c.Target] = haState
+		if c.Up["NameNodeStatus"] {
+			g_haLastTransition[c.Target] = lastTransition
+		} else {
+			delete(g_haLastTransition, c.Target)
+		}
+
+		haStates := []string{"active", "standby", "observer"}
+		if haState != "active" && haState != "standby" && haState != "observer" {
+			haStates = append(haStates, haState)
+		}
+		for _, state := range haStates {
+			value := 0
+			if state == haState {
+				value = 1
+			}
+			ret += fmt.Sprintf("%s_ha_state{state=\"%s\",role=\"%s\"} %d\n",
+				nameSpace, state, *role, value)
+		}
+		ret += fmt.Sprintf("%s_ha_transitions_total{role=\"%s\"} %g\n",
+			nameSpace, *role, g_haTransitions[c.Target])
+	}
+	if c.Up["NameNodeStatus"] {
+		ret += fmt.Sprintf("%s_ha_last_transition_time_seconds{role=\"%s\"} %g\n",
+			nameSpace, *role, s.NameNodeStatusInfo.LastHATransitionTime/1000)
+	}
+
+	if sm := s.NameNodeInfo.SafeMode; sm.State != "" {
+		// Safe mode, in which HDFS is read-only
+		if g_safeMode[c.Target] != "" && sm.State != g_safeMode[c.Target] {
+			collector.LogAt(collector.LevelWarn, "safe mode changed", "target", c.Target, "from", g_safeMode[c.Target], "to", sm.State)
+		}
+		g_safeMode[c.Target] = sm.State
+
+		for _, state := range safeModeStates {
+			value := 0
+			if state == sm.State {
+				value = 1
+			}
+			ret += fmt.Sprintf("%s_safe_mode{state=\"%s\",role=\"%s\"} %d\n",
+				nameSpace, state, *role, value)
+		}
+		if sm.HasThreshold {
+			ret += fmt.Sprintf("%s_safe_mode_reported_blocks{role=\"%s\"} %g\n",
+				nameSpace, *role, sm.ReportedBlocks)
+			ret += fmt.Sprintf("%s_safe_mode_needed_blocks{role=\"%s\"} %g\n",
+				nameSpace, *role, sm.NeededBlocks)
+			ret += fmt.Sprintf("%s_safe_mode_threshold{role=\"%s\"} %g\n",
+				nameSpace, *role, sm.Threshold)
+			ret += fmt.Sprintf("%s_safe_mode_total_blocks{role=\"%s\"} %g\n",
+				nameSpace, *role, sm.TotalBlocks)
+		}
+	}
+
+	if c.Up["NameNodeInfo"] {
+		// Build, which differs per NameNode during a rolling upgrade
+		ret += collector.BuildInfo("version", s.NameNodeInfo.Version, "revision", s.NameNodeInfo.Revision,
+			"compiled", s.NameNodeInfo.CompileDate, "cluster_id", s.NameNodeInfo.ClusterId,
+			"block_pool_id", s.NameNodeInfo.BlockPoolId, "jvm_version", s.RuntimeInfo.JvmVersion)
+	}
+	if c.Up["Runtime"] {
+		ret += collector.RuntimeMetrics(s.RuntimeInfo)
+	}
+
+	if c.Up["StartupProgress"] {
+		// StartupProgress, which is reported while the NameNode still starts
+		ret += fmt.Sprintf("%s_startup_progress_percent_complete{role=\"%s\"} %g\n",
+			nameSpace, *role, s.StartupProgressInfo.PercentComplete)
+		ret += fmt.Sprintf("%s_startup_progress_elapsed_time_millis{role=\"%s\"} %g\n",
+			nameSpace, *role, s.StartupProgressInfo.ElapsedTime)
+		for _, p := range s.StartupProgressInfo.Phases {
+			phase := collector.SnakeCase(p.Name)
+			ret += fmt.Sprintf("%s_startup_phase_count{phase=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, phase, *role, p.Count)
+			ret += fmt.Sprintf("%s_startup_phase_total{phase=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, phase, *role, p.Total)
+			ret += fmt.Sprintf("%s_startup_phase_percent_complete{phase=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, phase, *role, p.PercentComplete)
+			ret += fmt.Sprintf("%s_startup_phase_elapsed_time_millis{phase=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, phase, *role, p.ElapsedTime)
+		}
+	}
+
+	return ret
+}
+
+// clusterMetrics renders the namespace-wide state, which only the active
+// NameNode of a nameservice reports reliably.
+func clusterMetrics(s HadoopNameNodeJmxInfo, c *collector.Collection) string {
+	ret := ""
+	nameSpace := "hadoop_"
+
+	if c.Up["FSNamesystem"] {
+		// FSNamesystem
+		ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"missing\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemInfo.MissingBlocks)
+		ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"total\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemInfo.BlocksTotal)
+		ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"corrupt\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemInfo.CorruptBlocks)
+		ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"excess\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemInfo.ExcessBlocks)
+		ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"pending_repl\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemInfo.PendingReplicationBlocks)
+		ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"scheduled_repl\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemInfo.ScheduledReplicationBlocks)
+		ret += fmt.Sprintf("%s_fs_name_system_capacity{type=\"total\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemInfo.CapacityTotalGB)
+		ret += fmt.Sprintf("%s_fs_name_system_capacity{type=\"used\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemInfo.CapacityUsedGB)
+		ret += fmt.Sprintf("%s_fs_name_system_capacity{type=\"remaining\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemInfo.CapacityRemainingGB)
+		ret += fmt.Sprintf("%s_fs_name_system_files_total{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemInfo.FilesTotal)
+		ret += fmt.Sprintf("%s_fs_name_system_total_load{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemInfo.TotalLoad)
+		for _, datanodes := range []struct{ attr, state string }{
+			{"NumDecommissioningDataNodes", "decommissioning"},
+			{"NumDecomLiveDataNodes", "decommissioned_live"},
+			{"NumDecomDeadDataNodes", "decommissioned_dead"},
+			{"NumEnteringMaintenanceDataNodes", "entering_maintenance"},
+			{"NumInMaintenanceLiveDataNodes", "in_maintenance_live"},
+			{"NumInMaintenanceDeadDataNodes", "in_maintenance_dead"},
+			{"StaleDataNodes", "stale"},
+		} {
+			if v, ok := s.FSNamesystemInfo.Optional[datanodes.attr]; ok {
+				ret += fmt.Sprintf("%s_fs_name_system_datanodes{state=\"%s\",role=\"%s\"} %g\n",
+					nameSpace, datanodes.state, *role, v)
+			}
+		}
+		for _, blocks := range []struct{ attr, kind string }{
+			{"PendingDeletionBlocks", "pending_deletion"},
+			{"LowRedundancyBlocks", "low_redundancy"},
+			{"MissingReplOneBlocks", "missing_repl_one"},
+		} {
+			if v, ok := s.FSNamesystemInfo.Optional[blocks.attr]; ok {
+				ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"%s\",role=\"%s\"} %g\n",
+					nameSpace, blocks.kind, *role, v)
+			}
+		}
+		for _, gauge := range []struct {
+			attr, metric string
+			scale        float64
+		}{
+			{"LastCheckpointTime", "last_checkpoint_time_seconds", 0.001},
+			{"LastWrittenTransactionId", "last_written_transaction_id", 1},
+			{"MillisSinceLastLoadedEdits", "millis_since_last_loaded_edits", 1},
+			{"TransactionsSinceLastCheckpoint", "transactions_since_last_checkpoint", 1},
+			{"SnapshottableDirectories", "snapshottable_directories", 1},
+			{"Snapshots", "snapshots", 1},
+			{"NumEncryptionZones", "encryption_zones", 1},
+		} {
+			if v, ok := s.FSNamesystemInfo.Optional[gauge.attr]; ok {
+				ret += fmt.Sprintf("%s_fs_name_system_%s{role=\"%s\"} %g\n",
+					nameSpace, gauge.metric, *role, v*gauge.scale)
+			}
+		}
+	}
+
+	if c.Up["FSNamesystemState"] {
+		// FSNamesystemState
+		ret += fmt.Sprintf("%s_fs_name_system_state_capacity{type=\"total\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemStateInfo.CapacityTotal)
+		ret += fmt.Sprintf("%s_fs_name_system_state_capacity{type=\"used\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemStateInfo.CapacityUsed)
+		ret += fmt.Sprintf("%s_fs_name_system_state_capacity{type=\"remaining\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemStateInfo.CapacityRemaining)
+		ret += fmt.Sprintf("%s_fs_name_system_state_total_load{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemStateInfo.TotalLoad)
+		ret += fmt.Sprintf("%s_fs_name_system_state_blocks_total{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemStateInfo.BlocksTotal)
+		ret += fmt.Sprintf("%s_fs_name_system_state_files_total{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemStateInfo.FilesTotal)
+		ret += fmt.Sprintf("%s_fs_name_system_state_pending_replication_blocks{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemStateInfo.PendingReplicationBlocks)
+		ret += fmt.Sprintf("%s_fs_name_system_state_under_replicated_blocks{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemStateInfo.UnderReplicatedBlocks)
+		ret += fmt.Sprintf("%s_fs_name_system_state_scheduled_replication_blocks{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemStateInfo.ScheduledReplicationBlocks)
+		ret += fmt.Sprintf("%s_fs_name_system_state_num_live_datanodes{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemStateInfo.NumLiveDataNodes)
+		ret += fmt.Sprintf("%s_fs_name_system_state_num_dead_datanodes{role=\"%s\"} %g\n",
+			nameSpace, *role, s.FSNamesystemStateInfo.NumDeadDataNodes)
+		if v, ok := s.FSNamesystemStateInfo.Optional["NumStaleDataNodes"]; ok {
+			ret += fmt.Sprintf("%s_fs_name_system_state_num_stale_datanodes{role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+
+		// nntop
+		for _, window := range s.FSNamesystemStateInfo.TopUserOpCounts {
+			windowLen := strconv.FormatFloat(window.WindowLenMs/1000, 'f', -1, 64) + "s"
+			for _, op := range window.Ops {
+				ret += fmt.Sprintf("%s_top_ops{window=\"%s\",op=\"%s\",role=\"%s\"} %g\n",
+					nameSpace, windowLen, collector.EscapeLabel(op.OpType), *role, op.TotalCount)
+				users := op.TopUsers
+				sort.SliceStable(users, func(i, j int) bool { return users[i].Count > users[j].Count })
+				if len(users) > *topUsers {
+					users = users[:*topUsers]
+				}
+				for _, user := range users {
+					ret += fmt.Sprintf("%s_top_user_ops{window=\"%s\",op=\"%s\",user=\"%s\",role=\"%s\"} %g\n",
+						nameSpace, windowLen, collector.EscapeLabel(op.OpType), collector.EscapeLabel(user.User), *role, user.Count)
+				}
+			}
+		}
+	}
+
+	if c.Up["NameNodeInfo"] {
+		// NameNodeInfo
+		now := time.Now()
+		racks := map[string]string{}
+		for _, dn := range s.NameNodeInfo.LiveNodes {
+			racks[dn.HostName] = dn.Rack
+			labels := fmt.Sprintf("hostname=\"%s\",rack=\"%s\",role=\"%s\"", dn.HostName, dn.Rack, *role)
+			ret += fmt.Sprintf("%s_datanode_live{%s} 1\n", nameSpace, labels)
+			ret += fmt.Sprintf("%s_datanode_admin_state{%s,state=\"%s\"} 1\n", nameSpace, labels,
+				adminStateLabel(dn.AdminState))
+			switch dn.AdminState {
+			case "Decommission In Progress", "Entering Maintenance", "In Maintenance":
+				ret += fmt.Sprintf("%s_datanode_out_of_service{%s,state=\"%s\"} 1\n", nameSpace, labels,
+					adminStateLabel(dn.AdminState))
+			}
+			ret += fmt.Sprintf("%s_datanode_last_contact_seconds{%s} %g\n", nameSpace, labels, dn.LastContact)
+			ret += fmt.Sprintf("%s_datanode_capacity_bytes{%s} %g\n", nameSpace, labels, dn.Capacity)
+			ret += fmt.Sprintf("%s_datanode_used_bytes{%s} %g\n", nameSpace, labels, dn.Used)
+			ret += fmt.Sprintf("%s_datanode_remaining_bytes{%s} %g\n", nameSpace, labels, dn.Remaining)
+			ret += fmt.Sprintf("%s_datanode_non_dfs_used_bytes{%s} %g\n", nameSpace, labels, dn.NonDfsUsed)
+			ret += fmt.Sprintf("%s_datanode_blocks{%s} %g\n", nameSpace, labels, dn.NumBlocks)
+			ret += fmt.Sprintf("%s_datanode_volume_failures{%s} %g\n", nameSpace, labels, dn.VolumeFailures)
+			if dn.HasXceivers {
+				ret += fmt.Sprintf("%s_datanode_xceivers{%s} %g\n", nameSpace, labels, dn.XceiverCount)
+			}
+		}
+		for _, dn := range s.NameNodeInfo.DeadNodes {
+			if dn.Rack == "" {
+				dn.Rack = racks[dn.HostName]
+			}
+			labels := fmt.Sprintf("hostname=\"%s\",rack=\"%s\",role=\"%s\"", dn.HostName, dn.Rack, *role)
+			ret += fmt.Sprintf("%s_datanode_live{%s} 0\n", nameSpace, labels)
+			if dn.AdminState != "" {
+				ret += fmt.Sprintf("%s_datanode_admin_state{%s,state=\"%s\"} 1\n", nameSpace, labels,
+					adminStateLabel(dn.AdminState))
+			}
+			ret += fmt.Sprintf("%s_datanode_last_contact_seconds{%s} %g\n", nameSpace, labels, dn.LastContact)
+		}
+		for _, dn := range s.NameNodeInfo.DecomNodes {
+			labels := fmt.Sprintf("hostname=\"%s\",rack=\"%s\",role=\"%s\"", dn.HostName, racks[dn.HostName], *role)
+			ret += fmt.Sprintf("%s_datanode_decommission_blocks{%s,type=\"under_replicated\"} %g\n",
+				nameSpace, labels, dn.UnderReplicatedBlocks)
+			ret += fmt.Sprintf("%s_datanode_decommission_blocks{%s,type=\"decommission_only_replicas\"} %g\n",
+				nameSpace, labels, dn.DecommissionOnlyReplicas)
+			ret += fmt.Sprintf("%s_datanode_decommission_blocks{%s,type=\"under_replicated_in_open_files\"} %g\n",
+				nameSpace, labels, dn.UnderReplicatedInOpenFiles)
+			if eta, ok := estimateCompletion("decommission "+dn.HostName, dn.UnderReplicatedBlocks, now); ok {
+				ret += fmt.Sprintf("%s_datanode_decommission_estimated_completion_time_seconds{%s} %g\n",
+					nameSpace, labels, eta)
+			}
+		}
+		for _, dn := range s.NameNodeInfo.EnteringMaintenanceNodes {
+			labels := fmt.Sprintf("hostname=\"%s\",rack=\"%s\",role=\"%s\"", dn.HostName, racks[dn.HostName], *role)
+			ret += fmt.Sprintf("%s_datanode_maintenance_blocks{%s,type=\"under_replicated\"} %g\n",
+				nameSpace, labels, dn.UnderReplicatedBlocks)
+			ret += fmt.Sprintf("%s_datanode_maintenance_blocks{%s,type=\"maintenance_only_replicas\"} %g\n",
+				nameSpace, labels, dn.MaintenanceOnlyReplicas)
+			ret += fmt.Sprintf("%s_datanode_maintenance_blocks{%s,type=\"under_replicated_in_open_files\"} %g\n",
+				nameSpace, labels, dn.UnderReplicatedInOpenFiles)
+			if eta, ok := estimateCompletion("maintenance "+dn.HostName, dn.UnderReplicatedBlocks, now); ok {
+				ret += fmt.Sprintf("%s_datanode_maintenance_estimated_completion_time_seconds{%s} %g\n",
+					nameSpace, labels, eta)
+			}
+		}
+		for key, p := range g_outOfService {
+			if p.seen != now {
+				delete(g_outOfService, key)
+			}
+		}
+	}
+
+	if c.Up["NameNodeInfo"] {
+		// Slow peers and disks, with dfs.datanode.peer.stats.enabled and
+		// dfs.datanode.fileio.profiling.sampling.percentage set
+		if s.NameNodeInfo.HasSlowPeersReport {
+			slowNodes := map[string]bool{}
+			for _, p := range s.NameNodeInfo.SlowPeers {
+				slowNodes[p.SlowNode] = true
+				labels := fmt.Sprintf("slow_node=\"%s\",reporting_node=\"%s\",role=\"%s\"",
+					collector.EscapeLabel(p.SlowNode), collector.EscapeLabel(p.ReportingNode), *role)
+				ret += fmt.Sprintf("%s_slow_peer_report{%s} 1\n", nameSpace, labels)
+				if p.HasLatency {
+					ret += fmt.Sprintf("%s_slow_peer_latency_millis{%s} %g\n", nameSpace, labels, p.Latency)
+				}
+			}
+			ret += fmt.Sprintf("%s_slow_peers{role=\"%s\"} %d\n", nameSpace, *role, len(slowNodes))
+		}
+		if s.NameNodeInfo.HasSlowDisksReport {
+			disks := map[string]bool{}
+			for _, d := range s.NameNodeInfo.SlowDisks {
+				disks[d.Node+":"+d.Disk] = true
+				ret += fmt.Sprintf("%s_slow_disk_latency_millis{node=\"%s\",disk=\"%s\",op=\"%s\",role=\"%s\"} %g\n",
+					nameSpace, collector.EscapeLabel(d.Node), collector.EscapeLabel(d.Disk), d.Op, *role, d.Latency)
+			}
+			ret += fmt.Sprintf("%s_slow_disks{role=\"%s\"} %d\n", nameSpace, *role, len(disks))
+		}
+	}
+
+	// ReplicatedBlocksState and ECBlockGroupsState, Hadoop 3 only
+	for _, state := range s.BlocksStates {
+		for _, b := range blocksStateAttrs {
+			if b.kind != state.Kind {
+				continue
+			}
+			for _, attr := range b.attrs {
+				if v, ok := state.Counts[attr[1]]; ok {
+					ret += fmt.Sprintf("%s_blocks_state{kind=\"%s\",type=\"%s\",role=\"%s\"} %g\n",
+						nameSpace, state.Kind, attr[1], *role, v)
+				}
+			}
+		}
+	}
+
+	if c.Up["SnapshotInfo"] {
+		// SnapshotInfo, as a directory can have at most 65536 snapshots
+		for _, d := range s.SnapshottableDirectories {
+			ret += fmt.Sprintf("%s_snapshottable_directory_snapshots{path=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, collector.EscapeLabel(d.Path), *role, d.SnapshotNumber)
+			ret += fmt.Sprintf("%s_snapshottable_directory_snapshot_quota{path=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, collector.EscapeLabel(d.Path), *role, d.SnapshotQuota)
+		}
+	}
+
+	if c.Up["BlockStats"] {
+		// BlockStats, per storage type for tiered storage
+		for _, st := range s.StorageTypeStats {
+			for _, v := range []struct {
+				kind  string
+				value float64
+			}{
+				{"total", st.CapacityTotal},
+				{"used", st.CapacityUsed},
+				{"remaining", st.CapacityRemaining},
+				{"non_dfs_used", st.CapacityNonDfsUsed},
+				{"block_pool_used", st.BlockPoolUsed},
+			} {
+				ret += fmt.Sprintf("%s_storage_type_capacity_bytes{storage_type=\"%s\",type=\"%s\",role=\"%s\"} %g\n",
+					nameSpace, st.StorageType, v.kind, *role, v.value)
+			}
+			ret += fmt.Sprintf("%s_storage_type_nodes_in_service{storage_type=\"%s\",role=\"%s\"} %g\n",
+				nameSpace, st.StorageType, *role, st.NodesInService)
+		}
+	}
+
+	return ret
+}
+
 func doWork() {
 	if g_doing {
 		return
 	}
 	g_doing = true
 
-	s, ok := info()
-	if !ok {
-		g_doing = false
-		return
+	nns := nameNodes()
+	cs := make([]*collector.Collection, len(nns))
+	infos := make([]HadoopNameNodeJmxInfo, len(nns))
+	var fns []func()
+	for i, nn := range nns {
+		i := i
+		cs[i] = collector.NewCollection(nn.url, "jmx", "Memory", "FSNamesystem", "FSNamesystemState",
+			"NameNodeActivity", "JvmMetrics", "NameNodeStatus", "NameNodeInfo", "StartupProgress", "BlockStats", "Runtime", "SnapshotInfo")
+		fns = append(fns, func() { infos[i], _ = info(cs[i]) })
 	}
+	collector.Parallel(*concurrency, fns...)
 
 	ret := ""
 	nameSpace := "hadoop_"
 
-	// Memory
-	ret += fmt.Sprintf("%s_heap_memory{type=\"committed\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.MemoryInfo.heapMemoryUsageCommitted)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"init\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.MemoryInfo.heapMemoryUsageInit)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"max\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.MemoryInfo.heapMemoryUsageMax)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"used\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.MemoryInfo.heapMemoryUsageUsed)
-
-	// FSNamesystem
-	ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"missing\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemInfo.MissingBlocks)
-	ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"total\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemInfo.BlocksTotal)
-	ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"corrupt\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemInfo.CorruptBlocks)
-	ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"excess\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemInfo.ExcessBlocks)
-	ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"pending_repl\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemInfo.PendingReplicationBlocks)
-	ret += fmt.Sprintf("%s_fs_name_system_blocks{type=\"scheduled_repl\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemInfo.ScheduledReplicationBlocks)
-	ret += fmt.Sprintf("%s_fs_name_system_capacity{type=\"total\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemInfo.CapacityTotalGB)
-	ret += fmt.Sprintf("%s_fs_name_system_capacity{type=\"used\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemInfo.CapacityUsedGB)
-	ret += fmt.Sprintf("%s_fs_name_system_capacity{type=\"remaining\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemInfo.CapacityRemainingGB)
-	ret += fmt.Sprintf("%s_fs_name_system_files_total{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemInfo.FilesTotal)
-	ret += fmt.Sprintf("%s_fs_name_system_total_load{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemInfo.TotalLoad)
-
-	// FSNamesystemState
-	ret += fmt.Sprintf("%s_fs_name_system_state_capacity{type=\"total\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemStateInfo.CapacityTotal)
-	ret += fmt.Sprintf("%s_fs_name_system_state_capacity{type=\"used\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemStateInfo.CapacityUsed)
-	ret += fmt.Sprintf("%s_fs_name_system_state_capacity{type=\"remaining\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemStateInfo.CapacityRemaining)
-	ret += fmt.Sprintf("%s_fs_name_system_state_total_load{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemStateInfo.TotalLoad)
-	ret += fmt.Sprintf("%s_fs_name_system_state_blocks_total{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemStateInfo.BlocksTotal)
-	ret += fmt.Sprintf("%s_fs_name_system_state_files_total{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemStateInfo.FilesTotal)
-	ret += fmt.Sprintf("%s_fs_name_system_state_pending_replication_blocks{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemStateInfo.PendingReplicationBlocks)
-	ret += fmt.Sprintf("%s_fs_name_system_state_under_replicated_blocks{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemStateInfo.UnderReplicatedBlocks)
-	ret += fmt.Sprintf("%s_fs_name_system_state_scheduled_replication_blocks{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemStateInfo.ScheduledReplicationBlocks)
-	ret += fmt.Sprintf("%s_fs_name_system_state_num_live_datanodes{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemStateInfo.NumLiveDataNodes)
-	ret += fmt.Sprintf("%s_fs_name_system_state_num_dead_datanodes{role=\"%s\"} %g\n",
-		nameSpace, *role, s.FSNamesystemStateInfo.NumDeadDataNodes)
-
-	// NameNodeActivity
-	ret += fmt.Sprintf("%s_activity_create_file_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.CreateFileOps)
-	ret += fmt.Sprintf("%s_activity_file_created{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.FilesCreated)
-	ret += fmt.Sprintf("%s_activity_create_file_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.CreateFileOps)
-	ret += fmt.Sprintf("%s_activity_get_block_locations{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.GetBlockLocations)
-	ret += fmt.Sprintf("%s_activity_files_renamed{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.FilesRenamed)
-	ret += fmt.Sprintf("%s_activity_get_listing_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.GetListingOps)
-	ret += fmt.Sprintf("%s_activity_get_delete_file_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.DeleteFileOps)
-	ret += fmt.Sprintf("%s_activity_get_files_deleted{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.FilesDeleted)
-	ret += fmt.Sprintf("%s_activity_file_info_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.FileInfoOps)
-
-	ret += fmt.Sprintf("%s_activity_block_add_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.AddBlockOps)
-	ret += fmt.Sprintf("%s_activity_get_additional_datanode_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.GetAdditionalDatanodeOps)
-	ret += fmt.Sprintf("%s_activity_create_symlink_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.CreateSymlinkOps)
-	ret += fmt.Sprintf("%s_activity_get_link_target_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.GetLinkTargetOps)
-	ret += fmt.Sprintf("%s_activity_files_in_get_listing_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.FilesInGetListingOps)
-	ret += fmt.Sprintf("%s_activity_storage_block_report_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.StorageBlockReportOps)
-	ret += fmt.Sprintf("%s_activity_transactions_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.TransactionsNumOps)
-	ret += fmt.Sprintf("%s_activity_transactions_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.TransactionsAvgTime)
-
-	ret += fmt.Sprintf("%s_activity_syncs_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.SyncsNumOps)
-	ret += fmt.Sprintf("%s_activity_syncs_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.SyncsAvgTime)
-	ret += fmt.Sprintf("%s_activity_transactions_batched_in_sync{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.TransactionsBatchedInSync)
-	ret += fmt.Sprintf("%s_activity_block_report_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.BlockReportNumOps)
-	ret += fmt.Sprintf("%s_activity_block_report_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.BlockReportAvgTime)
-	ret += fmt.Sprintf("%s_activity_safemode_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.SafeModeTime)
-	ret += fmt.Sprintf("%s_activity_fs_image_load_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.FsImageLoadTime)
-
-	ret += fmt.Sprintf("%s_activity_get_edit_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.GetEditNumOps)
-	ret += fmt.Sprintf("%s_activity_get_edit_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.GetEditAvgTime)
-	ret += fmt.Sprintf("%s_activity_get_image_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.GetImageNumOps)
-	ret += fmt.Sprintf("%s_activity_get_image_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.GetImageAvgTime)
-	ret += fmt.Sprintf("%s_activity_put_image_num_ops{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.PutImageNumOps)
-	ret += fmt.Sprintf("%s_activity_put_image_avg_time{role=\"%s\"} %g\n",
-		nameSpace, *role, s.NameNodeActivityInfo.PutImageAvgTime)
-
-	// JvmMetrics
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_time_total_millis{role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcTimeMillis)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"par_new\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcTimeMillisParNew)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"concurrent_mark_sweep\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcTimeMillisConcurrentMarkSweep)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_count_total{role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcCount)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_count{type=\"par_new\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcCountParNew)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_count{type=\"concurrent_mark_sweep\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcCountConcurrentMarkSweep)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_blocked{role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.ThreadsBlocked)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_waiting{role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.ThreadsWaiting)
+	// Cluster-wide metrics come from the active NameNode. Should several
+	// claim to be active, the one that became active last is believed.
+	active, actives := -1, 0
+	for i, nn := range nns {
+		ret += withLabel(instanceMetrics(infos[i], cs[i]), "nn_id", nn.id)
+		ret += withLabel(collector.SourceMetrics(cs[i]), "nn_id", nn.id)
+		if infos[i].haState() == "active" {
+			actives++
+			if active < 0 || infos[i].NameNodeStatusInfo.LastHATransitionTime >
+				infos[active].NameNodeStatusInfo.LastHATransitionTime {
+				active = i
+			}
+		}
+	}
+	if len(nns) == 1 {
+		active = 0
+	} else {
+		if actives != 1 {
+			collector.LogAt(collector.LevelWarn, "nameservice does not have exactly one active NameNode",
+				"active", actives)
+		}
+		ret += fmt.Sprintf("%s_ha_active_namenodes{role=\"%s\"} %d\n",
+			nameSpace, *role, actives)
+	}
+	if active >= 0 {
+		ret += clusterMetrics(infos[active], cs[active])
+		if *quotaPaths != "" {
+			ret += directoryMetrics(nns[active].url)
+		}
+	}
 
 	g_lock.Lock()
 	g_ret = ret
@@ -396,6 +2062,10 @@ func doWork() {
 
 func main() {
 	flag.Parse()
+	if err := collector.Init(Name, *role); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	g_doing = false
 	doWork()
@@ -405,12 +2075,15 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc(*metricsPath, metrics)
+	mux.HandleFunc("/log/level", collector.LogLevel)
+	mux.HandleFunc("/debug/targets", collector.DebugTargets)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
              <head><title>Hadoop Name Node Exporter</title></head>
              <body>
              <h1>Hadoop Name Node Exporter</h1>
              <p><a href='` + *metricsPath + `'>Metrics</a></p>
+             <p><a href='/debug/targets'>Targets</a></p>
              </body>
              </html>`))
 	})
@@ -0,0 +1,486 @@
+package main
+
+// The exporters are separate main packages sharing this directory, so tests
+// are run per exporter: go test namenode_exporter.go namenode_exporter_test.go
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ximply/hadoop_exporter/internal/collector"
+)
+
+// fakeWebHDFS serves LISTSTATUS from tree, mapping a directory to its
+// entries, with names holding a dot being files. GETCONTENTSUMMARY and
+// GETQUOTAUSAGE answer from summaries and usages by path, or 404. It counts
+// the requests of every operation.
+type fakeWebHDFS struct {
+	tree      map[string][]string
+	summaries map[string]string
+	usages    map[string]string
+	// Status GETQUOTAUSAGE is answered with where set, as by Hadoop 2.
+	quotaUsageStatus int
+
+	lock     sync.Mutex
+	requests map[string]int
+}
+
+func (f *fakeWebHDFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dir := strings.TrimPrefix(r.URL.Path, "/webhdfs/v1")
+	if dir == "" {
+		dir = "/"
+	}
+	op := r.FormValue("op")
+	f.lock.Lock()
+	f.requests[op]++
+	f.lock.Unlock()
+
+	switch op {
+	case "LISTSTATUS":
+		type status struct {
+			PathSuffix string `json:"pathSuffix"`
+			Type       string `json:"type"`
+		}
+		statuses := []status{}
+		for _, name := range f.tree[dir] {
+			t := "DIRECTORY"
+			if strings.Contains(name, ".") {
+				t = "FILE"
+			}
+			statuses = append(statuses, status{name, t})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"FileStatuses": map[string]interface{}{"FileStatus": statuses},
+		})
+	case "GETCONTENTSUMMARY":
+		f.reply(w, "ContentSummary", f.summaries[dir])
+	case "GETQUOTAUSAGE":
+		if f.quotaUsageStatus != 0 {
+			http.Error(w, `{"RemoteException":{"exception":"IllegalArgumentException"}}`, f.quotaUsageStatus)
+			return
+		}
+		f.reply(w, "QuotaUsage", f.usages[dir])
+	default:
+		http.Error(w, "unknown op", http.StatusBadRequest)
+	}
+}
+
+func (f *fakeWebHDFS) reply(w http.ResponseWriter, key, body string) {
+	if body == "" {
+		http.NotFound(w, nil)
+		return
+	}
+	w.Write([]byte(`{"` + key + `":` + body + `}`))
+}
+
+// start serves f, returning the JMX URL of its NameNode.
+func (f *fakeWebHDFS) start() (string, func()) {
+	f.requests = map[string]int{}
+	srv := httptest.NewServer(f)
+	return srv.URL + "/jmx", srv.Close
+}
+
+func TestMain(m *testing.M) {
+	// Rejected requests and undecodable attributes are expected here.
+	flag.Set("log.level", "error")
+	collector.Init(Name, *role)
+	os.Exit(m.Run())
+}
+
+func TestExpandGlob(t *testing.T) {
+	f := &fakeWebHDFS{tree: map[string][]string{
+		"/":        {"user", "data", "README.txt"},
+		"/user":    {"bob", "alice", "notes.txt"},
+		"/data":    {"a1", "a2", "b1"},
+		"/data/a1": {"logs"},
+		"/data/a2": {"tmp"},
+		"/data/b1": {"logs"},
+	}}
+	jmxUrl, stop := f.start()
+	defer stop()
+	base := webhdfsBase(jmxUrl)
+
+	for _, test := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"/user/*", []string{"/user/alice", "/user/bob"}},
+		{"/data/a?", []string{"/data/a1", "/data/a2"}},
+		{"/data/[ab]1/logs", []string{"/data/a1/logs", "/data/b1/logs"}},
+		{"/*", []string{"/data", "/user"}},
+		{"/user/carol", []string{"/user/carol"}},
+		{"/user/nobody*", nil},
+	} {
+		got, ok := expandGlob(base, test.pattern)
+		if !ok {
+			t.Errorf("expandGlob(%q) failed", test.pattern)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("expandGlob(%q) = %q, want %q", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestDirectoryUsage(t *testing.T) {
+	f := &fakeWebHDFS{
+		summaries: map[string]string{
+			"/user/alice": `{"directoryCount":2,"fileCount":5,"length":1000,"quota":100,
+				"spaceConsumed":3000,"spaceQuota":-1,
+				"typeQuota":{"SSD":{"consumed":1000,"quota":5000},"DISK":{"consumed":2000,"quota":-1}}}`,
+		},
+		usages: map[string]string{
+			"/user/alice": `{"fileAndDirectoryCount":8,"quota":100,"spaceConsumed":3001,
+				"spaceQuota":10000,"typeQuota":{}}`,
+		},
+	}
+	jmxUrl, stop := f.start()
+	defer stop()
+	base := webhdfsBase(jmxUrl)
+
+	got, ok := directoryUsage(base, "/user/alice", true)
+	if !ok {
+		t.Fatal("directoryUsage failed")
+	}
+	want := DirectoryUsage{
+		Path:              "/user/alice",
+		FileCount:         5,
+		DirectoryCount:    2,
+		Length:            1000,
+		NamespaceUsed:     8,
+		NamespaceQuota:    100,
+		SpaceConsumed:     3001,
+		SpaceQuota:        10000,
+		HasContentSummary: true,
+		// The empty typeQuota of the quota usage leaves the one of the
+		// content summary.
+		TypeUsage: []StorageTypeUsage{
+			{StorageType: "DISK", Consumed: 2000, Quota: -1},
+			{StorageType: "SSD", Consumed: 1000, Quota: 5000},
+		},
+		undecodable:      map[string]string{},
+		quotaUsageStatus: http.StatusOK,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("directoryUsage = %+v, want %+v", got, want)
+	}
+
+	// Without quota usage the content summary is all there is.
+	got, ok = directoryUsage(base, "/user/alice", false)
+	if !ok {
+		t.Fatal("directoryUsage without quota usage failed")
+	}
+	if got.NamespaceUsed != 7 || got.SpaceConsumed != 3000 || got.SpaceQuota != -1 || got.quotaUsageStatus != 0 {
+		t.Errorf("directoryUsage without quota usage = %+v", got)
+	}
+	if n := f.requests["GETQUOTAUSAGE"]; n != 1 {
+		t.Errorf("GETQUOTAUSAGE requested %d times, want 1", n)
+	}
+
+	if _, ok := directoryUsage(base, "/user/nobody", true); ok {
+		t.Error("directoryUsage of a missing directory succeeded")
+	}
+}
+
+func TestDirectoryMetrics(t *testing.T) {
+	f := &fakeWebHDFS{
+		tree: map[string][]string{"/user": {"alice", "bob"}},
+		summaries: map[string]string{
+			"/user/alice": `{"directoryCount":1,"fileCount":1,"length":10,"quota":100,
+				"spaceConsumed":30,"spaceQuota":300,
+				"typeQuota":{"SSD":{"consumed":30,"quota":100},"DISK":{"consumed":0,"quota":-1}}}`,
+			"/user/bob": `{"directoryCount":1,"fileCount":2,"length":20,"quota":-1,
+				"spaceConsumed":60,"spaceQuota":-1,"typeQuota":{}}`,
+		},
+		quotaUsageStatus: http.StatusBadRequest,
+	}
+	jmxUrl, stop := f.start()
+	defer stop()
+	defer func(paths string) { *quotaPaths = paths }(*quotaPaths)
+	*quotaPaths = "/user/*"
+
+	ret := directoryMetrics(jmxUrl)
+	for _, line := range []string{
+		`hadoop__directory_namespace_quota{path="/user/alice",role="NameNode"} 100`,
+		`hadoop__directory_space_quota_bytes{path="/user/alice",role="NameNode"} 300`,
+		`hadoop__directory_type_space_quota_bytes{path="/user/alice",role="NameNode",storage_type="SSD"} 100`,
+		`hadoop__directory_type_space_consumed_bytes{path="/user/alice",role="NameNode",storage_type="DISK"} 0`,
+		`hadoop__directory_namespace_used{path="/user/bob",role="NameNode"} 3`,
+		`hadoop_exporter_source_up{source="webhdfs",role="NameNode"} 1`,
+		`hadoop_exporter_decode_errors_total{source="webhdfs",role="NameNode"} 0`,
+	} {
+		if !strings.Contains(ret, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+	// Quotas of -1 are not set and left out.
+	for _, metric := range []string{
+		`hadoop__directory_type_space_quota_bytes{path="/user/alice",role="NameNode",storage_type="DISK"}`,
+		`hadoop__directory_namespace_quota{path="/user/bob",`,
+		`hadoop__directory_space_quota_bytes{path="/user/bob",`,
+	} {
+		if strings.Contains(ret, metric) {
+			t.Errorf("unexpected %s", metric)
+		}
+	}
+
+	// A NameNode rejecting GETQUOTAUSAGE is not asked again.
+	directoryMetrics(jmxUrl)
+	if n := f.requests["GETQUOTAUSAGE"]; n != 2 {
+		t.Errorf("GETQUOTAUSAGE requested %d times, want 2 from the first cycle only", n)
+	}
+}
+
+func TestSafeMode(t *testing.T) {
+	for _, test := range []struct {
+		tip  string
+		want SafeMode
+	}{
+		{"", SafeMode{State: "off"}},
+		{
+			"Safe mode is ON. The reported blocks 0 needs additional 10 blocks to reach the threshold 0.9990 of total blocks 11.\n" +
+				"The minimum number of live datanodes is not required. Safe mode will be turned off automatically once the thresholds have been reached.",
+			SafeMode{State: "automatic", ReportedBlocks: 0, NeededBlocks: 10, Threshold: 0.999, TotalBlocks: 11, HasThreshold: true},
+		},
+		{
+			"Safe mode is ON. The reported blocks 100 has reached the threshold 0.9990 of total blocks 100. " +
+				"The minimum number of live datanodes is not required. In safe mode extension. Safe mode will be turned off automatically in 25 seconds.",
+			SafeMode{State: "automatic", ReportedBlocks: 100, Threshold: 0.999, TotalBlocks: 100, HasThreshold: true},
+		},
+		{
+			`Safe mode is ON. It was turned on manually. Use "hdfs dfsadmin -safemode leave" to turn safe mode off.`,
+			SafeMode{State: "manual"},
+		},
+		{
+			"Resources are low on NN. Please add or free up more resources then turn off safe mode manually. " +
+				`NOTE:  If you turn off safe mode before adding resources, the NN will immediately return to safe mode. Use "hdfs dfsadmin -safemode leave" to turn safe mode off.`,
+			SafeMode{State: "resources_low"},
+		},
+	} {
+		if got := safeMode(test.tip); got != test.want {
+			t.Errorf("safeMode(%q) = %+v, want %+v", test.tip, got, test.want)
+		}
+	}
+}
+
+// jmxInfo reads beans, the JSON objects of a JMX payload, with info.
+func jmxInfo(t *testing.T, beans ...string) (HadoopNameNodeJmxInfo, *collector.Collection) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"beans":[` + strings.Join(beans, ",") + `]}`))
+	}))
+	defer srv.Close()
+	c := collector.NewCollection(srv.URL+"/jmx", "jmx")
+	s, ok := info(c)
+	if !ok {
+		t.Fatal("info failed")
+	}
+	return s, c
+}
+
+func TestTopUserOpCounts(t *testing.T) {
+	top, _ := json.Marshal(`{"timestamp":"2024-01-01T00:00:00+0000","windows":[` +
+		`{"windowLenMs":60000,"ops":[{"opType":"create","topUsers":[` +
+		`{"user":"alice","count":1},{"user":"bob","count":5},{"user":"carol","count":3}],"totalCount":9}]},` +
+		`{"windowLenMs":300000,"ops":[]}]}`)
+	s, c := jmxInfo(t, `{"name":"Hadoop:service=NameNode,name=FSNamesystemState","NNTopUserOpCounts":`+string(top)+`}`)
+
+	windows := s.FSNamesystemStateInfo.TopUserOpCounts
+	if len(windows) != 2 || windows[0].WindowLenMs != 60000 || len(windows[0].Ops) != 1 {
+		t.Fatalf("TopUserOpCounts = %+v", windows)
+	}
+	if op := windows[0].Ops[0]; op.OpType != "create" || op.TotalCount != 9 || len(op.TopUsers) != 3 {
+		t.Errorf("create op = %+v", op)
+	}
+
+	defer func(n int) { *topUsers = n }(*topUsers)
+	*topUsers = 2
+	ret := clusterMetrics(s, c)
+	for _, line := range []string{
+		`hadoop__top_ops{window="60s",op="create",role="NameNode"} 9`,
+		`hadoop__top_user_ops{window="60s",op="create",user="bob",role="NameNode"} 5`,
+		`hadoop__top_user_ops{window="60s",op="create",user="carol",role="NameNode"} 3`,
+	} {
+		if !strings.Contains(ret, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+	if strings.Contains(ret, `user="alice"`) {
+		t.Error("user beyond -nntop.top-users exported")
+	}
+
+	_, c = jmxInfo(t, `{"name":"Hadoop:service=NameNode,name=FSNamesystemState","TopUserOpCounts":"{"}`)
+	failed := c.Failed["FSNamesystemState"]
+	if len(failed) == 0 || failed[len(failed)-1] != "TopUserOpCounts" {
+		t.Errorf("undecodable TopUserOpCounts not recorded, failed attributes are %q", failed)
+	}
+}
+
+func TestSlowPeers(t *testing.T) {
+	c := collector.NewCollection("", "NameNodeInfo")
+	got, ok := slowPeers(c, `[{"SlowNode":"dn1:9866","ReportingNodes":["dn2:9866","dn3:9866"]},`+
+		`{"SlowNode":"dn4:9866","SlowPeerLatencyWithReportingNodes":[{"ReportingNode":"dn5:9866","ReportedLatency":15.5}]}]`)
+	if !ok {
+		t.Fatal("slowPeers failed")
+	}
+	want := []SlowPeer{
+		{SlowNode: "dn1:9866", ReportingNode: "dn2:9866"},
+		{SlowNode: "dn1:9866", ReportingNode: "dn3:9866"},
+		{SlowNode: "dn4:9866", ReportingNode: "dn5:9866", Latency: 15.5, HasLatency: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("slowPeers = %+v, want %+v", got, want)
+	}
+
+	if got, ok := slowPeers(c, "[]"); !ok || len(got) != 0 {
+		t.Errorf("slowPeers of an empty report = %+v, %v", got, ok)
+	}
+	if _, ok := slowPeers(c, "{"); ok || !reflect.DeepEqual(c.Failed["NameNodeInfo"], []string{"SlowPeersReport"}) {
+		t.Errorf("undecodable report not recorded, failed attributes are %q", c.Failed["NameNodeInfo"])
+	}
+}
+
+func TestSlowDisks(t *testing.T) {
+	c := collector.NewCollection("", "NameNodeInfo")
+	got, ok := slowDisks(c, `[{"SlowDiskID":"dn1:disk1","Latencies":{"WRITE":3.5,"READ":20}},`+
+		`{"SlowDiskID":"dn2","Latencies":{"METADATA":1}}]`)
+	if !ok {
+		t.Fatal("slowDisks failed")
+	}
+	want := []SlowDisk{
+		{Node: "dn1", Disk: "disk1", Op: "read", Latency: 20},
+		{Node: "dn1", Disk: "disk1", Op: "write", Latency: 3.5},
+		{Node: "dn2", Op: "metadata", Latency: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("slowDisks = %+v, want %+v", got, want)
+	}
+
+	if _, ok := slowDisks(c, "{"); ok || !reflect.DeepEqual(c.Failed["NameNodeInfo"], []string{"SlowDisksReport"}) {
+		t.Errorf("undecodable report not recorded, failed attributes are %q", c.Failed["NameNodeInfo"])
+	}
+}
+
+func TestDataNodes(t *testing.T) {
+	c := collector.NewCollection("", "NameNodeInfo")
+	bean := map[string]interface{}{
+		"LiveNodes": `{"dn2.example.com:9866":{"infoAddr":"10.0.0.2:9864","xceiverCount":12,` +
+			`"location":"/rack2","lastContact":1,"adminState":"In Service","capacity":1000,"used":400,` +
+			`"remaining":500,"nonDfsUsedSpace":100,"numBlocks":40,"volfails":1},` +
+			`"dn1.example.com:9866":{"location":"/rack1","lastContact":0,"adminState":"Decommission In Progress",` +
+			`"capacity":2000,"used":100,"remaining":1800,"nonDfsUsedSpace":100,"numBlocks":10,"volfails":0}}`,
+		"DecomNodes": `{"dn1.example.com:9866":{"xferaddr":"10.0.0.1:9866","underReplicatedBlocks":7,` +
+			`"decommissionOnlyReplicas":2,"underReplicateInOpenFiles":1}}`,
+		"DeadNodes":                "{}",
+		"EnteringMaintenanceNodes": "not json",
+	}
+
+	want := []DataNodeStatus{
+		{HostName: "dn1.example.com", Rack: "/rack1", AdminState: "Decommission In Progress",
+			Capacity: 2000, Used: 100, Remaining: 1800, NonDfsUsed: 100, NumBlocks: 10},
+		{HostName: "dn2.example.com", Rack: "/rack2", AdminState: "In Service", LastContact: 1,
+			Capacity: 1000, Used: 400, Remaining: 500, NonDfsUsed: 100, NumBlocks: 40, VolumeFailures: 1,
+			XceiverCount: 12, HasXceivers: true},
+	}
+	if got := dataNodes(c, bean, "LiveNodes"); !reflect.DeepEqual(got, want) {
+		t.Errorf("dataNodes(LiveNodes) = %+v, want %+v", got, want)
+	}
+
+	want = []DataNodeStatus{
+		{HostName: "dn1.example.com", UnderReplicatedBlocks: 7, DecommissionOnlyReplicas: 2,
+			UnderReplicatedInOpenFiles: 1},
+	}
+	if got := dataNodes(c, bean, "DecomNodes"); !reflect.DeepEqual(got, want) {
+		t.Errorf("dataNodes(DecomNodes) = %+v, want %+v", got, want)
+	}
+
+	if got := dataNodes(c, bean, "DeadNodes"); len(got) != 0 {
+		t.Errorf("dataNodes(DeadNodes) = %+v, want none", got)
+	}
+	if len(c.Failed["NameNodeInfo"]) != 0 {
+		t.Errorf("failed attributes %q, want none", c.Failed["NameNodeInfo"])
+	}
+
+	if got := dataNodes(c, bean, "EnteringMaintenanceNodes"); got != nil {
+		t.Errorf("dataNodes of undecodable nodes = %+v, want none", got)
+	}
+	if !reflect.DeepEqual(c.Failed["NameNodeInfo"], []string{"EnteringMaintenanceNodes"}) {
+		t.Errorf("undecodable nodes not recorded, failed attributes are %q", c.Failed["NameNodeInfo"])
+	}
+
+	var s HadoopNameNodeJmxInfo
+	s.NameNodeInfo.LiveNodes = dataNodes(c, bean, "LiveNodes")
+	c.Up["NameNodeInfo"] = true
+	ret := clusterMetrics(s, c)
+	for _, line := range []string{
+		`hadoop__datanode_admin_state{hostname="dn1.example.com",rack="/rack1",role="NameNode",state="decommission_in_progress"} 1`,
+		`hadoop__datanode_admin_state{hostname="dn2.example.com",rack="/rack2",role="NameNode",state="in_service"} 1`,
+		`hadoop__datanode_out_of_service{hostname="dn1.example.com",rack="/rack1",role="NameNode",state="decommission_in_progress"} 1`,
+	} {
+		if !strings.Contains(ret, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+}
+
+func TestHATransitions(t *testing.T) {
+	// haCycle is what the NameNode reports in one collection cycle, and the
+	// transitions counted after it.
+	type haCycle struct {
+		state      string
+		transition float64
+		start      float64
+		want       float64
+	}
+	for _, test := range []struct {
+		name   string
+		cycles []haCycle
+	}{
+		{"failover", []haCycle{
+			{"standby", 1000, 1, 0},
+			{"active", 2000, 1, 1},
+			{"active", 2000, 1, 1},
+		}},
+		{"failover and back", []haCycle{
+			{"active", 1000, 1, 0},
+			{"active", 3000, 1, 2},
+		}},
+		{"restart", []haCycle{
+			{"active", 1000, 1, 0},
+			{"active", 0, 5000, 0},
+			{"standby", 6000, 5000, 1},
+		}},
+		{"restart into another state", []haCycle{
+			{"active", 1000, 1, 0},
+			{"standby", 0, 5000, 0},
+		}},
+	} {
+		var cycle haCycle
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"beans":[`+
+				`{"name":"Hadoop:service=NameNode,name=NameNodeStatus","State":"%s","LastHATransitionTime":%g},`+
+				`{"name":"java.lang:type=Runtime","StartTime":%g,"Uptime":1}]}`,
+				cycle.state, cycle.transition, cycle.start)
+		}))
+		for i := range test.cycles {
+			cycle = test.cycles[i]
+			c := collector.NewCollection(srv.URL+"/jmx", "jmx")
+			s, ok := info(c)
+			if !ok {
+				t.Fatal("info failed")
+			}
+			line := fmt.Sprintf("hadoop__ha_transitions_total{role=\"NameNode\"} %g\n", cycle.want)
+			if ret := instanceMetrics(s, c); !strings.Contains(ret, line) {
+				t.Errorf("%s: cycle %d: missing %s", test.name, i, line)
+			}
+		}
+		srv.Close()
+	}
+}
@@ -6,12 +6,14 @@ import (
 	"os"
 	"net/http"
 	"io"
-	"github.com/parnurzeal/gorequest"
 	"github.com/robfig/cron"
-	"time"
 	"fmt"
 	"sync"
 	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/ximply/hadoop_exporter/internal/collector"
 )
 
 var (
@@ -21,6 +23,7 @@ var (
 	rmUrl = flag.String("rm.url", "http://localhost:8088", "Hadoop resource manager URL.")
 	jmxUrl = flag.String("jmx.url", "http://localhost:8088/jmx", "Hadoop resource manager JMX URL.")
 	role           = flag.String("role", "ResourceManager", "Role type.")
+	concurrency      = flag.Int("collect.concurrency", 4, "Maximum number of sources fetched at the same time.")
 )
 
 var g_doing bool
@@ -59,94 +62,157 @@ type DetailInfo struct {
 	GcCount float64
 	ThreadsBlocked float64
 	ThreadsWaiting float64
+
+	RuntimeInfo collector.Runtime
+
+	Quantiles []collector.Quantile
+}
+
+// ClusterInfo is the build and identity of the ResourceManager.
+type ClusterInfo struct {
+	ClusterId   string
+	Version     string
+	Revision    string
+	CompileDate string
 }
 
 func metrics(w http.ResponseWriter, r *http.Request) {
 	g_lock.RLock()
 	io.WriteString(w, g_ret)
 	g_lock.RUnlock()
+	io.WriteString(w, collector.BreakerMetrics())
 }
 
-func detailInfo() (DetailInfo, bool) {
+func detailInfo(c *collector.Collection) (DetailInfo, bool) {
 	ret := DetailInfo{
 
 	}
 
 	// http://localhost:8088/jmx
-	req := gorequest.New()
-	_, body, errs := req.Retry(1, 5 * time.Second,
-		http.StatusBadRequest, http.StatusInternalServerError).Get(*jmxUrl).End()
-	if errs != nil {
+	body, ok := collector.Fetch(c.Target)
+	if !ok {
 		return ret, false
 	}
 
 	var f interface{}
 	err := json.Unmarshal([]byte(body), &f)
 	if err != nil {
+		collector.LogAt(collector.LevelError, "cannot decode payload", "target", c.Target, "err", err)
 		return ret, false
 	}
-	m := f.(map[string]interface{})
-	var nameList = m["beans"].([]interface{})
+	m, _ := f.(map[string]interface{})
+	nameList, ok := m["beans"].([]interface{})
+	if !ok {
+		collector.LogAt(collector.LevelError, "payload has no beans", "target", c.Target)
+		return ret, false
+	}
+	c.Up["jmx"] = true
 	for _, nameData := range nameList {
-		nameDataMap := nameData.(map[string]interface{})
+		nameDataMap, _ := nameData.(map[string]interface{})
+		ret.Quantiles = append(ret.Quantiles, collector.Quantiles(nameDataMap)...)
 
 		if nameDataMap["name"] == "java.lang:type=Memory" {
-			heapMemoryUsage := nameDataMap["HeapMemoryUsage"].(map[string]interface{})
-			ret.HeapMemoryUsageCommitted = heapMemoryUsage["committed"].(float64)
-			ret.HeapMemoryUsageInit = heapMemoryUsage["init"].(float64)
-			ret.HeapMemoryUsageMax = heapMemoryUsage["max"].(float64)
-			ret.HeapMemoryUsageUsed = heapMemoryUsage["used"].(float64)
+			get := c.Bean("Memory", nameDataMap["HeapMemoryUsage"])
+			ret.HeapMemoryUsageCommitted = get("committed")
+			ret.HeapMemoryUsageInit = get("init")
+			ret.HeapMemoryUsageMax = get("max")
+			ret.HeapMemoryUsageUsed = get("used")
+		}
+
+		if nameDataMap["name"] == "java.lang:type=Runtime" {
+			ret.RuntimeInfo = collector.RuntimeInfo(c, nameDataMap)
 		}
 
 		if nameDataMap["name"] == "Hadoop:service=ResourceManager,name=JvmMetrics" {
-			ret.GcTimeMillis = nameDataMap["GcTimeMillis"].(float64)
-			ret.GcCount = nameDataMap["GcCount"].(float64)
-			ret.ThreadsBlocked = nameDataMap["ThreadsBlocked"].(float64)
-			ret.ThreadsWaiting = nameDataMap["ThreadsWaiting"].(float64)
+			get := c.Bean("JvmMetrics", nameDataMap)
+			ret.GcTimeMillis = get("GcTimeMillis")
+			ret.GcCount = get("GcCount")
+			ret.ThreadsBlocked = get("ThreadsBlocked")
+			ret.ThreadsWaiting = get("ThreadsWaiting")
 		}
 	}
 
 	return ret, true
 }
 
-func info() (RmInfo, bool) {
+func info(c *collector.Collection) (RmInfo, bool) {
 	ret := RmInfo {
 	}
 	// http://localhost:8088/ws/v1/cluster/metrics
-	req := gorequest.New()
-	_, body, errs := req.Retry(1, 5 * time.Second,
-		http.StatusBadRequest, http.StatusInternalServerError).Get(
-			fmt.Sprintf("%s/ws/v1/cluster/metrics", *rmUrl)).End()
-	if errs != nil {
+	body, ok := collector.Fetch(c.Target)
+	if !ok {
 		return ret, false
 	}
 
 	var f interface{}
 	err := json.Unmarshal([]byte(body), &f)
 	if err != nil {
+		collector.LogAt(collector.LevelError, "cannot decode payload", "target", c.Target, "err", err)
 		return ret, false
 	}
-	m := f.(map[string]interface{})
-	cm := m["clusterMetrics"].(map[string]interface{})
-	ret.AppsSubmitted = cm["appsSubmitted"].(float64)
-	ret.AppsCompleted = cm["appsCompleted"].(float64)
-	ret.AppsPending = cm["appsPending"].(float64)
-	ret.AppsRunning = cm["appsRunning"].(float64)
-	ret.AppsFailed = cm["appsFailed"].(float64)
-	ret.AppsKilled = cm["appsKilled"].(float64)
-	ret.ReservedMB = cm["reservedMB"].(float64)
-	ret.AvailableMB = cm["availableMB"].(float64)
-	ret.AllocatedMB = cm["allocatedMB"].(float64)
-	ret.ContainersAllocated = cm["containersAllocated"].(float64)
-	ret.ContainersReserved = cm["containersReserved"].(float64)
-	ret.ContainersPending = cm["containersPending"].(float64)
-	ret.TotalMB = cm["totalMB"].(float64)
-	ret.TotalNodes = cm["totalNodes"].(float64)
-	ret.LostNodes = cm["lostNodes"].(float64)
-	ret.UnhealthyNodes = cm["unhealthyNodes"].(float64)
-	ret.DecommissionedNodes = cm["decommissionedNodes"].(float64)
-	ret.RebootedNodes = cm["rebootedNodes"].(float64)
-	ret.ActiveNodes = cm["activeNodes"].(float64)
+	m, _ := f.(map[string]interface{})
+	get := c.Bean("cluster_metrics", m["clusterMetrics"])
+	if !c.Up["cluster_metrics"] {
+		collector.LogAt(collector.LevelError, "payload has no clusterMetrics", "target", c.Target)
+		return ret, false
+	}
+	ret.AppsSubmitted = get("appsSubmitted")
+	ret.AppsCompleted = get("appsCompleted")
+	ret.AppsPending = get("appsPending")
+	ret.AppsRunning = get("appsRunning")
+	ret.AppsFailed = get("appsFailed")
+	ret.AppsKilled = get("appsKilled")
+	ret.ReservedMB = get("reservedMB")
+	ret.AvailableMB = get("availableMB")
+	ret.AllocatedMB = get("allocatedMB")
+	ret.ContainersAllocated = get("containersAllocated")
+	ret.ContainersReserved = get("containersReserved")
+	ret.ContainersPending = get("containersPending")
+	ret.TotalMB = get("totalMB")
+	ret.TotalNodes = get("totalNodes")
+	ret.LostNodes = get("lostNodes")
+	ret.UnhealthyNodes = get("unhealthyNodes")
+	ret.DecommissionedNodes = get("decommissionedNodes")
+	ret.RebootedNodes = get("rebootedNodes")
+	ret.ActiveNodes = get("activeNodes")
+
+	return ret, true
+}
+
+func clusterInfo(c *collector.Collection) (ClusterInfo, bool) {
+	ret := ClusterInfo{}
+	// http://localhost:8088/ws/v1/cluster/info
+	body, ok := collector.Fetch(c.Target)
+	if !ok {
+		return ret, false
+	}
+
+	var f struct {
+		ClusterInfo struct {
+			Id                            float64 `json:"id"`
+			ResourceManagerVersion        string  `json:"resourceManagerVersion"`
+			ResourceManagerBuildVersion   string  `json:"resourceManagerBuildVersion"`
+			ResourceManagerVersionBuiltOn string  `json:"resourceManagerVersionBuiltOn"`
+		} `json:"clusterInfo"`
+	}
+	err := json.Unmarshal([]byte(body), &f)
+	if err != nil {
+		collector.LogAt(collector.LevelError, "cannot decode payload", "target", c.Target, "err", err)
+		return ret, false
+	}
+	ci := f.ClusterInfo
+	if ci.ResourceManagerVersion == "" {
+		collector.LogAt(collector.LevelError, "payload has no clusterInfo", "target", c.Target)
+		return ret, false
+	}
+	c.Up["cluster_info"] = true
+	ret.ClusterId = strconv.FormatFloat(ci.Id, 'f', -1, 64)
+	ret.Version = ci.ResourceManagerVersion
+	ret.CompileDate = ci.ResourceManagerVersionBuiltOn
+	// <version> from <revision> by <user> source checksum <checksum>
+	if fields := strings.Fields(ci.ResourceManagerBuildVersion); len(fields) > 2 && fields[1] == "from" {
+		ret.Revision = fields[2]
+	}
 
 	return ret, true
 }
@@ -157,77 +223,93 @@ func doWork() {
 	}
 	g_doing = true
 
-	s, ok := info()
-	if !ok {
-		g_doing = false
-		return
+	var s RmInfo
+	var s1 DetailInfo
+	var s2 ClusterInfo
+	c := collector.NewCollection(fmt.Sprintf("%s/ws/v1/cluster/metrics", *rmUrl), "cluster_metrics")
+	c1 := collector.NewCollection(*jmxUrl, "jmx", "Memory", "JvmMetrics", "Runtime")
+	c2 := collector.NewCollection(fmt.Sprintf("%s/ws/v1/cluster/info", *rmUrl), "cluster_info")
+	collector.Parallel(*concurrency,
+		func() { s, _ = info(c) },
+		func() { s1, _ = detailInfo(c1) },
+		func() { s2, _ = clusterInfo(c2) })
+
+	ret := ""
+	nameSpace := "hadoop_"
+
+	if c.Up["cluster_metrics"] {
+		ret += fmt.Sprintf("%s_nodes{type=\"active\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.ActiveNodes)
+		ret += fmt.Sprintf("%s_nodes{type=\"rebooted\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.RebootedNodes)
+		ret += fmt.Sprintf("%s_nodes{type=\"decommissioned\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.DecommissionedNodes)
+		ret += fmt.Sprintf("%s_nodes{type=\"unhealthy\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.UnhealthyNodes)
+		ret += fmt.Sprintf("%s_nodes{type=\"lost\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.LostNodes)
+		ret += fmt.Sprintf("%s_nodes{type=\"total\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.TotalNodes)
+
+
+		ret += fmt.Sprintf("%s_containers{type=\"allocated\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.ContainersAllocated)
+		ret += fmt.Sprintf("%s_containers{type=\"reserved\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.ContainersReserved)
+		ret += fmt.Sprintf("%s_containers{type=\"pending\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.ContainersPending)
+
+		ret += fmt.Sprintf("%s_apps{type=\"killed\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.AppsKilled)
+		ret += fmt.Sprintf("%s_apps{type=\"failed\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.AppsFailed)
+		ret += fmt.Sprintf("%s_apps{type=\"running\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.AppsRunning)
+		ret += fmt.Sprintf("%s_apps{type=\"pending\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.AppsPending)
+
+		ret += fmt.Sprintf("%s_space{type=\"available\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.AvailableMB)
+		ret += fmt.Sprintf("%s_space{type=\"reserved\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.ReservedMB)
+		ret += fmt.Sprintf("%s_space{type=\"allocated\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.AllocatedMB)
+		ret += fmt.Sprintf("%s_space{type=\"total\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.TotalMB)
 	}
 
-	s1, ok := detailInfo()
-	if !ok {
-		g_doing = false
-		return
+	if c1.Up["Memory"] {
+		ret += fmt.Sprintf("%s_heap_memory{type=\"committed\",role=\"%s\"} %g\n",
+			nameSpace, *role, s1.HeapMemoryUsageCommitted)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"init\",role=\"%s\"} %g\n",
+			nameSpace, *role, s1.HeapMemoryUsageInit)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"max\",role=\"%s\"} %g\n",
+			nameSpace, *role, s1.HeapMemoryUsageMax)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"used\",role=\"%s\"} %g\n",
+			nameSpace, *role, s1.HeapMemoryUsageUsed)
 	}
 
-	ret := ""
-	nameSpace := "hadoop_"
+	if c1.Up["JvmMetrics"] {
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_time_total_millis{role=\"%s\"} %g\n",
+			nameSpace, *role, s1.GcTimeMillis)
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_blocked{type=\"par_new\",role=\"%s\"} %g\n",
+			nameSpace, *role, s1.ThreadsBlocked)
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_count_total{role=\"%s\"} %g\n",
+			nameSpace, *role, s1.GcCount)
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_waiting{type=\"par_new\",role=\"%s\"} %g\n",
+			nameSpace, *role, s1.ThreadsWaiting)
+	}
 
-	ret += fmt.Sprintf("%s_nodes{type=\"active\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.ActiveNodes)
-	ret += fmt.Sprintf("%s_nodes{type=\"rebooted\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.RebootedNodes)
-	ret += fmt.Sprintf("%s_nodes{type=\"decommissioned\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.DecommissionedNodes)
-	ret += fmt.Sprintf("%s_nodes{type=\"unhealthy\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.UnhealthyNodes)
-	ret += fmt.Sprintf("%s_nodes{type=\"lost\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.LostNodes)
-	ret += fmt.Sprintf("%s_nodes{type=\"total\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.TotalNodes)
-
-
-	ret += fmt.Sprintf("%s_containers{type=\"allocated\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.ContainersAllocated)
-	ret += fmt.Sprintf("%s_containers{type=\"reserved\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.ContainersReserved)
-	ret += fmt.Sprintf("%s_containers{type=\"pending\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.ContainersPending)
-
-	ret += fmt.Sprintf("%s_apps{type=\"killed\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.AppsKilled)
-	ret += fmt.Sprintf("%s_apps{type=\"failed\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.AppsFailed)
-	ret += fmt.Sprintf("%s_apps{type=\"running\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.AppsRunning)
-	ret += fmt.Sprintf("%s_apps{type=\"pending\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.AppsPending)
-
-	ret += fmt.Sprintf("%s_space{type=\"available\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.AvailableMB)
-	ret += fmt.Sprintf("%s_space{type=\"reserved\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.ReservedMB)
-	ret += fmt.Sprintf("%s_space{type=\"allocated\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.AllocatedMB)
-	ret += fmt.Sprintf("%s_space{type=\"total\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.TotalMB)
-
-	ret += fmt.Sprintf("%s_heap_memory{type=\"committed\",role=\"%s\"} %g\n",
-		nameSpace, *role, s1.HeapMemoryUsageCommitted)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"init\",role=\"%s\"} %g\n",
-		nameSpace, *role, s1.HeapMemoryUsageInit)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"max\",role=\"%s\"} %g\n",
-		nameSpace, *role, s1.HeapMemoryUsageMax)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"used\",role=\"%s\"} %g\n",
-		nameSpace, *role, s1.HeapMemoryUsageUsed)
-
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_time_total_millis{role=\"%s\"} %g\n",
-		nameSpace, *role, s1.GcTimeMillis)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_blocked{type=\"par_new\",role=\"%s\"} %g\n",
-		nameSpace, *role, s1.ThreadsBlocked)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_count_total{role=\"%s\"} %g\n",
-		nameSpace, *role, s1.GcCount)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_waiting{type=\"par_new\",role=\"%s\"} %g\n",
-		nameSpace, *role, s1.ThreadsWaiting)
+	if c2.Up["cluster_info"] {
+		ret += collector.BuildInfo("version", s2.Version, "revision", s2.Revision, "compiled", s2.CompileDate,
+			"cluster_id", s2.ClusterId, "jvm_version", s1.RuntimeInfo.JvmVersion)
+	}
+	if c1.Up["Runtime"] {
+		ret += collector.RuntimeMetrics(s1.RuntimeInfo)
+	}
+
+	ret += collector.QuantileMetrics(s1.Quantiles)
+	ret += collector.SourceMetrics(c, c1, c2)
 
 	g_lock.Lock()
 	g_ret = ret
@@ -238,6 +320,10 @@ func doWork() {
 
 func main() {
 	flag.Parse()
+	if err := collector.Init(Name, *role); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	g_doing = false
 	doWork()
@@ -247,12 +333,15 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc(*metricsPath, metrics)
+	mux.HandleFunc("/log/level", collector.LogLevel)
+	mux.HandleFunc("/debug/targets", collector.DebugTargets)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
              <head><title>Hadoop Resource Manager Exporter</title></head>
              <body>
              <h1>Hadoop Resource Manager Exporter</h1>
              <p><a href='` + *metricsPath + `'>Metrics</a></p>
+             <p><a href='/debug/targets'>Targets</a></p>
              </body>
              </html>`))
 	})
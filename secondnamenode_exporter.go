@@ -6,12 +6,14 @@ import (
 	"os"
 	"net/http"
 	"io"
-	"github.com/parnurzeal/gorequest"
 	"github.com/robfig/cron"
 	"time"
 	"fmt"
 	"sync"
 	"encoding/json"
+	"strings"
+
+	"github.com/ximply/hadoop_exporter/internal/collector"
 )
 
 var (
@@ -20,6 +22,8 @@ var (
 	metricsPath    = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
 	nameNodeJmxUrl = flag.String("jmx.url", "http://localhost:50090/jmx", "Hadoop second namenode JMX URL.")
 	role           = flag.String("role", "SecondaryNameNode", "Role type.")
+	nnJmxUrl       = flag.String("namenode.jmx.url", "", "Hadoop namenode JMX URL to read the transactions since the last checkpoint from, if set.")
+	concurrency    = flag.Int("collect.concurrency", 4, "Maximum number of sources fetched at the same time.")
 )
 
 var g_doing bool
@@ -29,6 +33,22 @@ var g_lock sync.RWMutex
 type HadoopNameNodeJmxInfo struct {
 	MemoryInfo Memory
 	JvmMetricsInfo JvmMetrics
+	CheckpointInfo SecondaryNameNodeInfo
+	RuntimeInfo collector.Runtime
+	Quantiles []collector.Quantile
+}
+
+type SecondaryNameNodeInfo struct {
+	LastCheckpointTime float64
+	// LastCheckpointDeltaMs is -1 before the first checkpoint, and absent
+	// before Hadoop 2.7, where LastCheckpointTime is wall clock time.
+	LastCheckpointDeltaMs *float64
+	CheckpointPeriod float64
+	TxnCount float64
+	CheckpointDirectories float64
+	Version string
+	Revision string
+	CompileDate string
 }
 
 type Memory struct {
@@ -40,11 +60,10 @@ type Memory struct {
 
 type JvmMetrics struct {
 	GcTimeMillis float64
-	GcTimeMillisParNew float64
-	GcTimeMillisConcurrentMarkSweep float64
 	GcCount float64
-	GcCountParNew float64
-	GcCountConcurrentMarkSweep float64
+	// Per-collector GC times and counts, only reported by JVMs running the
+	// ParNew and CMS collectors.
+	GcCollectors map[string]float64
 	ThreadsBlocked float64
 	ThreadsWaiting float64
 }
@@ -54,95 +73,211 @@ func metrics(w http.ResponseWriter, r *http.Request) {
 	g_lock.RLock()
 	io.WriteString(w, g_ret)
 	g_lock.RUnlock()
+	io.WriteString(w, collector.BreakerMetrics())
 }
 
-func info() (HadoopNameNodeJmxInfo, bool) {
+func info(c *collector.Collection) (HadoopNameNodeJmxInfo, bool) {
 	ret := HadoopNameNodeJmxInfo {
 	}
 	// http://localhost:50090/jmx
-	req := gorequest.New()
-	_, body, errs := req.Retry(1, 5 * time.Second,
-		http.StatusBadRequest, http.StatusInternalServerError).Get(*nameNodeJmxUrl).End()
-	if errs != nil {
+	body, ok := collector.Fetch(c.Target)
+	if !ok {
 		return ret, false
 	}
 
 	var f interface{}
 	err := json.Unmarshal([]byte(body), &f)
 	if err != nil {
+		collector.LogAt(collector.LevelError, "cannot decode payload", "target", c.Target, "err", err)
 		return ret, false
 	}
-	m := f.(map[string]interface{})
-	var nameList = m["beans"].([]interface{})
+	m, _ := f.(map[string]interface{})
+	nameList, ok := m["beans"].([]interface{})
+	if !ok {
+		collector.LogAt(collector.LevelError, "payload has no beans", "target", c.Target)
+		return ret, false
+	}
+	c.Up["jmx"] = true
 	for _, nameData := range nameList {
-		nameDataMap := nameData.(map[string]interface{})
+		nameDataMap, _ := nameData.(map[string]interface{})
+		ret.Quantiles = append(ret.Quantiles, collector.Quantiles(nameDataMap)...)
 
 		if nameDataMap["name"] == "java.lang:type=Memory" {
-			heapMemoryUsage := nameDataMap["HeapMemoryUsage"].(map[string]interface{})
-			ret.MemoryInfo.heapMemoryUsageCommitted = heapMemoryUsage["committed"].(float64)
-			ret.MemoryInfo.heapMemoryUsageInit = heapMemoryUsage["init"].(float64)
-			ret.MemoryInfo.heapMemoryUsageMax = heapMemoryUsage["max"].(float64)
-			ret.MemoryInfo.heapMemoryUsageUsed = heapMemoryUsage["used"].(float64)
+			get := c.Bean("Memory", nameDataMap["HeapMemoryUsage"])
+			ret.MemoryInfo.heapMemoryUsageCommitted = get("committed")
+			ret.MemoryInfo.heapMemoryUsageInit = get("init")
+			ret.MemoryInfo.heapMemoryUsageMax = get("max")
+			ret.MemoryInfo.heapMemoryUsageUsed = get("used")
 		}
 
 		if nameDataMap["name"] == "Hadoop:service=SecondaryNameNode,name=JvmMetrics" {
-			ret.JvmMetricsInfo.GcTimeMillis = nameDataMap["GcTimeMillis"].(float64)
-			ret.JvmMetricsInfo.GcTimeMillisParNew = nameDataMap["GcTimeMillisParNew"].(float64)
-			ret.JvmMetricsInfo.GcTimeMillisConcurrentMarkSweep = nameDataMap["GcTimeMillisConcurrentMarkSweep"].(float64)
-			ret.JvmMetricsInfo.GcCount = nameDataMap["GcCount"].(float64)
-			ret.JvmMetricsInfo.GcCountParNew = nameDataMap["GcCountParNew"].(float64)
-			ret.JvmMetricsInfo.GcCountConcurrentMarkSweep = nameDataMap["GcCountConcurrentMarkSweep"].(float64)
-			ret.JvmMetricsInfo.ThreadsBlocked = nameDataMap["ThreadsBlocked"].(float64)
-			ret.JvmMetricsInfo.ThreadsWaiting = nameDataMap["ThreadsWaiting"].(float64)
+			get := c.Bean("JvmMetrics", nameDataMap)
+			ret.JvmMetricsInfo.GcTimeMillis = get("GcTimeMillis")
+			ret.JvmMetricsInfo.GcCount = get("GcCount")
+			ret.JvmMetricsInfo.GcCollectors = collector.Optional(nameDataMap,
+				"GcTimeMillisParNew", "GcTimeMillisConcurrentMarkSweep",
+				"GcCountParNew", "GcCountConcurrentMarkSweep")
+			ret.JvmMetricsInfo.ThreadsBlocked = get("ThreadsBlocked")
+			ret.JvmMetricsInfo.ThreadsWaiting = get("ThreadsWaiting")
+		}
+
+		if nameDataMap["name"] == "java.lang:type=Runtime" {
+			ret.RuntimeInfo = collector.RuntimeInfo(c, nameDataMap)
+		}
+
+		if nameDataMap["name"] == "Hadoop:service=SecondaryNameNode,name=SecondaryNameNodeInfo" {
+			get := c.Bean("SecondaryNameNodeInfo", nameDataMap)
+			ret.CheckpointInfo.LastCheckpointTime = get("LastCheckpointTime")
+			ret.CheckpointInfo.CheckpointPeriod = get("CheckpointPeriod")
+			ret.CheckpointInfo.TxnCount = get("TxnCount")
+			if delta, ok := nameDataMap["LastCheckpointDeltaMs"].(float64); ok {
+				ret.CheckpointInfo.LastCheckpointDeltaMs = &delta
+			}
+			version, _ := nameDataMap["Version"].(string)
+			ret.CheckpointInfo.Version, ret.CheckpointInfo.Revision = collector.SplitVersion(version)
+			if compileInfo, ok := nameDataMap["CompileInfo"].(string); ok {
+				// <date> by <user> from <branch>
+				ret.CheckpointInfo.CompileDate = strings.SplitN(compileInfo, " ", 2)[0]
+			}
+			dirs, _ := nameDataMap["CheckpointDirectories"].([]interface{})
+			ret.CheckpointInfo.CheckpointDirectories = float64(len(dirs))
 		}
 	}
 
 	return ret, true
 }
 
+// transactionsSinceLastCheckpoint reads TransactionsSinceLastCheckpoint from
+// the FSNamesystem bean of the namenode, which is how many transactions the
+// checkpointer is behind.
+func transactionsSinceLastCheckpoint(c *collector.Collection) (float64, bool) {
+	// http://localhost:50070/jmx?qry=Hadoop:service=NameNode,name=FSNamesystem
+	body, ok := collector.Fetch(c.Target)
+	if !ok {
+		return 0, false
+	}
+
+	var f struct {
+		Beans []map[string]interface{} `json:"beans"`
+	}
+	if err := json.Unmarshal([]byte(body), &f); err != nil {
+		collector.LogAt(collector.LevelError, "cannot decode payload", "target", c.Target, "err", err)
+		return 0, false
+	}
+	c.Up[c.Sources[0]] = true
+	for _, bean := range f.Beans {
+		if bean["name"] == "Hadoop:service=NameNode,name=FSNamesystem" {
+			return c.Bean("NameNodeFSNamesystem", bean)("TransactionsSinceLastCheckpoint"), true
+		}
+	}
+	return 0, false
+}
+
+// checkpointAge returns how many seconds before now the last checkpoint
+// was. Before its first checkpoint the SecondaryNameNode has been without one
+// for as long as it has been running, if the Runtime bean tells.
+func checkpointAge(s HadoopNameNodeJmxInfo, c *collector.Collection, now time.Time) (float64, bool) {
+	if d := s.CheckpointInfo.LastCheckpointDeltaMs; d != nil && *d >= 0 {
+		return *d / 1000, true
+	} else if d == nil && s.CheckpointInfo.LastCheckpointTime > 0 {
+		return float64(now.UnixNano())/1e9 - s.CheckpointInfo.LastCheckpointTime/1000, true
+	} else if c.Up["Runtime"] {
+		return s.RuntimeInfo.Uptime / 1000, true
+	}
+	return 0, false
+}
+
 func doWork() {
 	if g_doing {
 		return
 	}
 	g_doing = true
 
-	s, ok := info()
-	if !ok {
-		g_doing = false
-		return
+	var s HadoopNameNodeJmxInfo
+	var behind float64
+	c := collector.NewCollection(*nameNodeJmxUrl, "jmx", "Memory", "JvmMetrics", "SecondaryNameNodeInfo", "Runtime")
+	cs := []*collector.Collection{c}
+	fns := []func(){func() { s, _ = info(c) }}
+	if *nnJmxUrl != "" {
+		c1 := collector.NewCollection(*nnJmxUrl, "namenode_jmx", "NameNodeFSNamesystem")
+		cs = append(cs, c1)
+		fns = append(fns, func() { behind, _ = transactionsSinceLastCheckpoint(c1) })
 	}
+	collector.Parallel(*concurrency, fns...)
 
 	ret := ""
 	nameSpace := "hadoop_"
 
-	// Memory
-	ret += fmt.Sprintf("%s_heap_memory{type=\"committed\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.MemoryInfo.heapMemoryUsageCommitted)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"init\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.MemoryInfo.heapMemoryUsageInit)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"max\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.MemoryInfo.heapMemoryUsageMax)
-	ret += fmt.Sprintf("%s_heap_memory{type=\"used\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.MemoryInfo.heapMemoryUsageUsed)
-
-
-	// JvmMetrics
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_time_total_millis{role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcTimeMillis)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"par_new\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcTimeMillisParNew)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"concurrent_mark_sweep\",role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcTimeMillisConcurrentMarkSweep)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_count_total{role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcCount)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_count{role=\"%s\",type=\"par_new\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcCountParNew)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_count{role=\"%s\",type=\"concurrent_mark_sweep\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.GcCountConcurrentMarkSweep)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_blocked{role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.ThreadsBlocked)
-	ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_waiting{role=\"%s\"} %g\n",
-		nameSpace, *role, s.JvmMetricsInfo.ThreadsWaiting)
+	if c.Up["Memory"] {
+		// Memory
+		ret += fmt.Sprintf("%s_heap_memory{type=\"committed\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.MemoryInfo.heapMemoryUsageCommitted)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"init\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.MemoryInfo.heapMemoryUsageInit)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"max\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.MemoryInfo.heapMemoryUsageMax)
+		ret += fmt.Sprintf("%s_heap_memory{type=\"used\",role=\"%s\"} %g\n",
+			nameSpace, *role, s.MemoryInfo.heapMemoryUsageUsed)
+	}
+
+	if c.Up["JvmMetrics"] {
+		// JvmMetrics
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_time_total_millis{role=\"%s\"} %g\n",
+			nameSpace, *role, s.JvmMetricsInfo.GcTimeMillis)
+		if v, ok := s.JvmMetricsInfo.GcCollectors["GcTimeMillisParNew"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"par_new\",role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		if v, ok := s.JvmMetricsInfo.GcCollectors["GcTimeMillisConcurrentMarkSweep"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_time_millis{type=\"concurrent_mark_sweep\",role=\"%s\"} %g\n",
+				nameSpace, *role, v)
+		}
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_count_total{role=\"%s\"} %g\n",
+			nameSpace, *role, s.JvmMetricsInfo.GcCount)
+		if v, ok := s.JvmMetricsInfo.GcCollectors["GcCountParNew"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_count{role=\"%s\",type=\"par_new\"} %g\n",
+				nameSpace, *role, v)
+		}
+		if v, ok := s.JvmMetricsInfo.GcCollectors["GcCountConcurrentMarkSweep"]; ok {
+			ret += fmt.Sprintf("%s_jvm_metrics_gc_count{role=\"%s\",type=\"concurrent_mark_sweep\"} %g\n",
+				nameSpace, *role, v)
+		}
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_blocked{role=\"%s\"} %g\n",
+			nameSpace, *role, s.JvmMetricsInfo.ThreadsBlocked)
+		ret += fmt.Sprintf("%s_jvm_metrics_gc_threads_waiting{role=\"%s\"} %g\n",
+			nameSpace, *role, s.JvmMetricsInfo.ThreadsWaiting)
+	}
+
+	if c.Up["SecondaryNameNodeInfo"] {
+		// SecondaryNameNodeInfo
+		if since, ok := checkpointAge(s, c, time.Now()); ok {
+			ret += fmt.Sprintf("%s_checkpoint_seconds_since_last{role=\"%s\"} %g\n",
+				nameSpace, *role, since)
+		}
+		ret += fmt.Sprintf("%s_checkpoint_period_seconds{role=\"%s\"} %g\n",
+			nameSpace, *role, s.CheckpointInfo.CheckpointPeriod)
+		ret += fmt.Sprintf("%s_checkpoint_txn_threshold{role=\"%s\"} %g\n",
+			nameSpace, *role, s.CheckpointInfo.TxnCount)
+		ret += fmt.Sprintf("%s_checkpoint_directories{role=\"%s\"} %g\n",
+			nameSpace, *role, s.CheckpointInfo.CheckpointDirectories)
+	}
+
+	if c.Up["SecondaryNameNodeInfo"] {
+		ret += collector.BuildInfo("version", s.CheckpointInfo.Version, "revision", s.CheckpointInfo.Revision,
+			"compiled", s.CheckpointInfo.CompileDate, "jvm_version", s.RuntimeInfo.JvmVersion)
+	}
+	if c.Up["Runtime"] {
+		ret += collector.RuntimeMetrics(s.RuntimeInfo)
+	}
+
+	if len(cs) > 1 && cs[1].Up["NameNodeFSNamesystem"] {
+		// TransactionsSinceLastCheckpoint of the namenode
+		ret += fmt.Sprintf("%s_checkpoint_transactions_behind{role=\"%s\"} %g\n",
+			nameSpace, *role, behind)
+	}
+
+	ret += collector.QuantileMetrics(s.Quantiles)
+	ret += collector.SourceMetrics(cs...)
 
 	g_lock.Lock()
 	g_ret = ret
@@ -153,6 +288,10 @@ func doWork() {
 
 func main() {
 	flag.Parse()
+	if err := collector.Init(Name, *role); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	g_doing = false
 	doWork()
@@ -162,12 +301,15 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc(*metricsPath, metrics)
+	mux.HandleFunc("/log/level", collector.LogLevel)
+	mux.HandleFunc("/debug/targets", collector.DebugTargets)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
              <head><title>Hadoop Second Name Node Exporter</title></head>
              <body>
              <h1>Hadoop Second Name Node Exporter</h1>
              <p><a href='` + *metricsPath + `'>Metrics</a></p>
+             <p><a href='/debug/targets'>Targets</a></p>
              </body>
              </html>`))
 	})
@@ -0,0 +1,40 @@
+package main
+
+// The exporters are separate main packages sharing this directory, so tests
+// are run per exporter: go test secondnamenode_exporter.go secondnamenode_exporter_test.go
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ximply/hadoop_exporter/internal/collector"
+)
+
+func TestCheckpointAge(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	delta := func(ms float64) *float64 { return &ms }
+	for _, test := range []struct {
+		name      string
+		info      SecondaryNameNodeInfo
+		runtimeUp bool
+		wantAge   float64
+		wantOK    bool
+	}{
+		{"delta", SecondaryNameNodeInfo{LastCheckpointDeltaMs: delta(5000), LastCheckpointTime: 1}, true, 5, true},
+		{"no checkpoint yet", SecondaryNameNodeInfo{LastCheckpointDeltaMs: delta(-1)}, true, 60, true},
+		{"no checkpoint yet, no runtime", SecondaryNameNodeInfo{LastCheckpointDeltaMs: delta(-1)}, false, 0, false},
+		{"wall clock before Hadoop 2.7", SecondaryNameNodeInfo{LastCheckpointTime: 1699999990000}, true, 10, true},
+		{"wall clock, no checkpoint yet", SecondaryNameNodeInfo{}, true, 60, true},
+		{"wall clock, no checkpoint yet, no runtime", SecondaryNameNodeInfo{}, false, 0, false},
+	} {
+		s := HadoopNameNodeJmxInfo{CheckpointInfo: test.info}
+		s.RuntimeInfo.Uptime = 60000
+		c := collector.NewCollection("http://localhost:50090/jmx", "jmx", "Runtime")
+		c.Up["Runtime"] = test.runtimeUp
+
+		age, ok := checkpointAge(s, c, now)
+		if age != test.wantAge || ok != test.wantOK {
+			t.Errorf("%s: checkpointAge = %g, %v, want %g, %v", test.name, age, ok, test.wantAge, test.wantOK)
+		}
+	}
+}